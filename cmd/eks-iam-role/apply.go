@@ -0,0 +1,250 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/ldx/eks_iam_role/pkg/awswrapper"
+	"github.com/ldx/eks_iam_role/pkg/diff"
+	"github.com/ldx/eks_iam_role/pkg/manifest"
+	"github.com/pkg/errors"
+)
+
+type applyCommand struct {
+	File string `long:"file" short:"f" description:"Path of the YAML or JSON manifest file" value-name:"FILE" env:"MANIFEST_FILE" required:"true"`
+}
+
+var applyCmd applyCommand
+
+// entryResult is the outcome of reconciling one manifest entry. Role entries
+// have a Policy result too, if the role declares its policy document.
+type entryResult struct {
+	Kind   string
+	Name   string
+	Policy *awswrapper.EnsureResult
+	Role   *awswrapper.EnsureResult
+	Err    error
+}
+
+func (r entryResult) changes() []awswrapper.Change {
+	var changes []awswrapper.Change
+	if r.Policy != nil {
+		changes = append(changes, r.Policy.Changes...)
+	}
+	if r.Role != nil {
+		changes = append(changes, r.Role.Changes...)
+	}
+	return changes
+}
+
+// arn returns the ARN of the role or policy of the entry.
+func (r entryResult) arn() string {
+	if r.Role != nil {
+		return r.Role.ARN
+	}
+	if r.Policy != nil {
+		return r.Policy.ARN
+	}
+	return ""
+}
+
+// entryOutput is the machine readable outcome of a manifest entry.
+type entryOutput struct {
+	Kind  string `json:"kind"`
+	Name  string `json:"name"`
+	Error string `json:"error,omitempty"`
+	result
+}
+
+type applyOutput struct {
+	Entries []entryOutput `json:"entries"`
+	Failed  int           `json:"failed"`
+}
+
+func (c *applyCommand) Execute(args []string) error {
+	if opts.ServiceAccountManifest || opts.AnnotateServiceAccounts {
+		return errors.New("--service-account-manifest and --annotate-service-accounts are not supported with apply")
+	}
+	m, err := manifest.Load(c.File)
+	if err != nil {
+		return err
+	}
+	tags, err := parseTags(opts.Tags)
+	if err != nil {
+		return errors.Wrapf(err, "parsing tags")
+	}
+	aw, err := awswrapper.New(opts.AWSRegion, opts.AWSEndpoint, opts.DryRun)
+	if err != nil {
+		return errors.Wrapf(err, "creating awswrapper")
+	}
+	results := applyManifest(aw, m, tags)
+	failed := 0
+	for _, r := range results {
+		if r.Err != nil {
+			failed++
+		}
+	}
+	if opts.Output == outputJSON {
+		if err := writeJSON(os.Stdout, newApplyOutput(results, failed)); err != nil {
+			return errors.Wrapf(err, "writing output")
+		}
+	} else {
+		printResults(results)
+	}
+	if failed > 0 {
+		return errors.Errorf("%d of %d manifest entries failed", failed, len(results))
+	}
+	return nil
+}
+
+func newApplyOutput(results []entryResult, failed int) applyOutput {
+	out := applyOutput{
+		Entries: []entryOutput{},
+		Failed:  failed,
+	}
+	for _, r := range results {
+		entry := entryOutput{
+			Kind:   r.Kind,
+			Name:   r.Name,
+			result: newResult(r.Policy, r.Role),
+		}
+		if r.Err != nil {
+			entry.Error = r.Err.Error()
+		}
+		out.Entries = append(out.Entries, entry)
+	}
+	return out
+}
+
+func printResults(results []entryResult) {
+	if opts.DryRun {
+		for _, r := range results {
+			if r.Err == nil {
+				fmt.Printf("Plan for %s %s:\n", r.Kind, r.Name)
+				printPlan(os.Stdout, r.changes(), diff.ColorEnabled(os.Stdout))
+			}
+		}
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	for _, r := range results {
+		if r.Err != nil {
+			fmt.Fprintf(w, "FAILED\t%s\t%s\t%v\n", r.Kind, r.Name, r.Err)
+		} else {
+			fmt.Fprintf(w, "OK\t%s\t%s\t%s\n", r.Kind, r.Name, r.arn())
+		}
+	}
+	w.Flush()
+}
+
+// applyManifest ensures every policy, then every role of the manifest. A
+// failing entry does not stop the others, but a role is skipped if its policy
+// could not be ensured. Policies declared on their own can be shared by
+// roles, so they only get the user tags.
+func applyManifest(aw awswrapper.AWSWrapper, m *manifest.Manifest, tags map[string]string) []entryResult {
+	var results []entryResult
+	failedPolicies := make(map[string]bool)
+	for _, p := range m.Policies {
+		result := entryResult{Kind: "policy", Name: p.Name}
+		result.Policy, result.Err = aw.EnsurePolicy(p.Name, p.Document, awswrapper.PolicyOptions{
+			Path: opts.PolicyPath,
+			Tags: tags,
+		})
+		if result.Err != nil {
+			failedPolicies[p.Name] = true
+		}
+		results = append(results, result)
+	}
+	issuers := make(map[string]string)
+	for _, r := range m.Roles {
+		result := entryResult{Kind: "role", Name: r.Name}
+		result.Policy, result.Role, result.Err = applyRole(aw, m, r, tags, failedPolicies, issuers)
+		results = append(results, result)
+	}
+	return results
+}
+
+// applyRole ensures the role and its policy, if it declares a document. The
+// OIDC issuers of clusters are cached in issuers, since roles usually share
+// clusters.
+func applyRole(aw awswrapper.AWSWrapper, m *manifest.Manifest, r manifest.Role, userTags map[string]string, failedPolicies map[string]bool, issuers map[string]string) (*awswrapper.EnsureResult, *awswrapper.EnsureResult, error) {
+	var serviceAccounts []awswrapper.ServiceAccount
+	for _, sa := range r.ServiceAccounts {
+		serviceAccounts = append(serviceAccounts, awswrapper.ServiceAccount{
+			Namespace: sa.Namespace,
+			Name:      sa.Name,
+		})
+	}
+	clusters, trusted := m.Trusted(r)
+	tags := awswrapper.MergeTags(awswrapper.OwnershipTags(clusters, serviceAccounts), userTags)
+	var policyResult *awswrapper.EnsureResult
+	policyName := r.PolicyName()
+	if len(r.PolicyDocument) > 0 && !r.InlinePolicy {
+		var err error
+		policyResult, err = aw.EnsurePolicy(policyName, r.PolicyDocument, awswrapper.PolicyOptions{
+			Path: opts.PolicyPath,
+			Tags: tags,
+		})
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "ensuring policy %s", policyName)
+		}
+	}
+	for _, policy := range r.Policies() {
+		if failedPolicies[policy] {
+			return nil, nil, errors.Errorf("policy %s failed", policy)
+		}
+	}
+	roleIssuers := append([]string(nil), trusted...)
+	var unresolved []string
+	for _, cluster := range clusters {
+		if _, ok := issuers[cluster]; !ok {
+			unresolved = append(unresolved, cluster)
+		}
+	}
+	if len(unresolved) > 0 {
+		resolved, err := aw.OIDCIssuersFromClusters(unresolved)
+		if err != nil {
+			return policyResult, nil, errors.Wrapf(err, "getting OIDC issuers")
+		}
+		for i, cluster := range unresolved {
+			issuers[cluster] = resolved[i]
+		}
+	}
+	for _, cluster := range clusters {
+		roleIssuers = append(roleIssuers, issuers[cluster])
+	}
+	trustOpts := awswrapper.TrustOptions{
+		ServiceAccounts:    serviceAccounts,
+		Audiences:          r.Audiences,
+		AllowBroadPatterns: r.AllowBroadPatterns,
+	}
+	trustPolicy, err := aw.TrustPolicyFromOIDCIssuers(roleIssuers, trustOpts)
+	if err != nil {
+		return policyResult, nil, errors.Wrapf(err, "getting trust policy")
+	}
+	roleOpts := awswrapper.RoleOptions{
+		Path:                      opts.RolePath,
+		PolicyPath:                opts.PolicyPath,
+		Tags:                      tags,
+		PermissionsBoundary:       opts.PermissionsBoundary,
+		RemovePermissionsBoundary: opts.RemovePermissionsBoundary,
+		Description:               r.Description,
+		MaxSessionDuration:        r.MaxSessionDuration,
+		ExclusivePolicies:         opts.ExclusivePolicies,
+		ExclusiveInlinePolicies:   opts.ExclusiveInlinePolicies,
+	}
+	if roleOpts.Description == "" {
+		roleOpts.Description = opts.RoleDescription
+	}
+	if roleOpts.MaxSessionDuration == 0 {
+		roleOpts.MaxSessionDuration = opts.MaxSessionDuration
+	}
+	if r.InlinePolicy {
+		roleOpts.InlinePolicies = map[string][]byte{policyName: r.PolicyDocument}
+	}
+	roleResult, err := aw.EnsureRole(r.Name, r.Policies(), trustPolicy, roleOpts)
+	if err != nil {
+		return policyResult, nil, errors.Wrapf(err, "ensuring role")
+	}
+	return policyResult, roleResult, nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/ldx/eks_iam_role/pkg/awswrapper"
+	"github.com/ldx/eks_iam_role/pkg/manifest"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockedAWSWrapper struct {
+	awswrapper.AWSWrapper
+	ensurePolicyErrs map[string]error
+	ensureRoleErrs   map[string]error
+	policies         []string
+	roles            []string
+	clusterLookups   [][]string
+}
+
+func (m *mockedAWSWrapper) EnsurePolicy(policyName string, policyDocument []byte, opts awswrapper.PolicyOptions) (*awswrapper.EnsureResult, error) {
+	if err := m.ensurePolicyErrs[policyName]; err != nil {
+		return nil, err
+	}
+	m.policies = append(m.policies, policyName)
+	return &awswrapper.EnsureResult{ARN: "arn:aws:iam::123456789012:policy/" + policyName}, nil
+}
+
+func (m *mockedAWSWrapper) EnsureRole(roleName string, policies []string, trustPolicy string, opts awswrapper.RoleOptions) (*awswrapper.EnsureResult, error) {
+	if err := m.ensureRoleErrs[roleName]; err != nil {
+		return nil, err
+	}
+	m.roles = append(m.roles, roleName)
+	return &awswrapper.EnsureResult{ARN: "arn:aws:iam::123456789012:role/" + roleName}, nil
+}
+
+func (m *mockedAWSWrapper) OIDCIssuersFromClusters(clusterNames []string) ([]string, error) {
+	m.clusterLookups = append(m.clusterLookups, clusterNames)
+	var issuers []string
+	for _, cluster := range clusterNames {
+		issuers = append(issuers, "oidc.eks.us-east-1.amazonaws.com/id/"+cluster)
+	}
+	return issuers, nil
+}
+
+func (m *mockedAWSWrapper) TrustPolicyFromOIDCIssuers(issuers []string, opts awswrapper.TrustOptions) (string, error) {
+	return `{}`, nil
+}
+
+func TestApplyManifest(t *testing.T) {
+	aw := &mockedAWSWrapper{
+		ensurePolicyErrs: map[string]error{
+			"broken-policy": errors.New("access denied"),
+			"broken-app":    errors.New("malformed policy document"),
+		},
+		ensureRoleErrs: map[string]error{
+			"failing-app": errors.New("role limit exceeded"),
+		},
+	}
+	m := &manifest.Manifest{
+		Clusters: []string{"prod"},
+		Policies: []manifest.Policy{
+			{Name: "shared-policy", Document: manifest.Document(`{}`)},
+			{Name: "broken-policy", Document: manifest.Document(`{}`)},
+		},
+		Roles: []manifest.Role{
+			{Name: "my-app", PolicyDocument: manifest.Document(`{}`), AttachPolicies: []string{"shared-policy"}},
+			{Name: "broken-app", PolicyDocument: manifest.Document(`{}`)},
+			{Name: "uses-broken", Policy: "broken-policy"},
+			{Name: "failing-app", Policy: "shared-policy"},
+			{Name: "dev-app", Policy: "shared-policy", Clusters: []string{"prod", "dev"}},
+		},
+	}
+	results := applyManifest(aw, m, nil)
+	var entries []string
+	failed := make(map[string]bool)
+	for _, r := range results {
+		entries = append(entries, r.Kind+"/"+r.Name)
+		failed[r.Name] = r.Err != nil
+	}
+	assert.Equal(t, []string{
+		"policy/shared-policy",
+		"policy/broken-policy",
+		"role/my-app",
+		"role/broken-app",
+		"role/uses-broken",
+		"role/failing-app",
+		"role/dev-app",
+	}, entries)
+	assert.Equal(t, map[string]bool{
+		"shared-policy": false,
+		"broken-policy": true,
+		"my-app":        false,
+		"broken-app":    true,
+		"uses-broken":   true,
+		"failing-app":   true,
+		"dev-app":       false,
+	}, failed)
+	// Failing entries don't stop the others, and roles whose policy failed
+	// are not ensured.
+	assert.Equal(t, []string{"shared-policy", "my-app"}, aw.policies)
+	assert.Equal(t, []string{"my-app", "dev-app"}, aw.roles)
+	assert.Equal(t, "arn:aws:iam::123456789012:role/my-app", results[2].arn())
+	// The OIDC issuers of clusters are looked up once.
+	assert.Equal(t, [][]string{{"prod"}, {"dev"}}, aw.clusterLookups)
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"time"
+
+	"github.com/ldx/eks_iam_role/pkg/apis/v1alpha1"
+	"github.com/ldx/eks_iam_role/pkg/awswrapper"
+	"github.com/ldx/eks_iam_role/pkg/controller"
+	"github.com/ldx/eks_iam_role/pkg/serviceaccount"
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+)
+
+const (
+	leaderElectionID = "eks-iam-role.ldx.github.com"
+)
+
+type controllerCommand struct {
+	RolePrefix              string        `long:"role-prefix" description:"Prefix of the names of roles and policies created for service accounts" env:"ROLE_PREFIX" required:"true"`
+	ResyncPeriod            time.Duration `long:"resync-period" description:"How often service accounts are reconciled to revert changes made to their roles" env:"RESYNC_PERIOD" default:"10m"`
+	LeaderElect             bool          `long:"leader-elect" description:"Enable leader election, so only one of the controller replicas is active" env:"LEADER_ELECT"`
+	LeaderElectionNamespace string        `long:"leader-election-namespace" description:"Namespace of the leader election lease, by default the namespace of the controller" env:"LEADER_ELECTION_NAMESPACE"`
+	MetricsBindAddress      string        `long:"metrics-bind-address" description:"Address the metrics endpoint binds to" env:"METRICS_BIND_ADDRESS" default:":8080"`
+	HealthProbeBindAddress  string        `long:"health-probe-bind-address" description:"Address the health probe endpoint binds to" env:"HEALTH_PROBE_BIND_ADDRESS" default:":8081"`
+	IAMServiceAccountRoles  bool          `long:"iam-service-account-roles" description:"Also reconcile IAMServiceAccountRole resources, their CRD needs to be installed" env:"IAM_SERVICE_ACCOUNT_ROLES"`
+}
+
+var controllerCmd controllerCommand
+
+func (c *controllerCommand) Execute(args []string) error {
+	if len(opts.OIDCIssuers) == 0 && len(opts.ClusterNames) == 0 {
+		return errors.New("at least one --oidc-issuer or --cluster-name needs to be set")
+	}
+	ctrl.SetLogger(zap.New())
+	aw, err := awswrapper.New(opts.AWSRegion, opts.AWSEndpoint, opts.DryRun)
+	if err != nil {
+		return errors.Wrapf(err, "creating awswrapper")
+	}
+	tags, err := parseTags(opts.Tags)
+	if err != nil {
+		return errors.Wrapf(err, "parsing tags")
+	}
+	clusterIssuers, err := aw.OIDCIssuersFromClusters(opts.ClusterNames)
+	if err != nil {
+		return errors.Wrapf(err, "getting OIDC issuers")
+	}
+	config, err := serviceaccount.NewRESTConfig(opts.Kubeconfig, opts.KubeContext)
+	if err != nil {
+		return err
+	}
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		return err
+	}
+	if err := v1alpha1.AddToScheme(scheme); err != nil {
+		return err
+	}
+	mgr, err := ctrl.NewManager(config, ctrl.Options{
+		Scheme:                  scheme,
+		LeaderElection:          c.LeaderElect,
+		LeaderElectionID:        leaderElectionID,
+		LeaderElectionNamespace: c.LeaderElectionNamespace,
+		MetricsBindAddress:      c.MetricsBindAddress,
+		HealthProbeBindAddress:  c.HealthProbeBindAddress,
+	})
+	if err != nil {
+		return errors.Wrapf(err, "creating manager")
+	}
+	issuers := append(append([]string(nil), opts.OIDCIssuers...), clusterIssuers...)
+	recorder := mgr.GetEventRecorderFor("eks-iam-role")
+	saReconciler := &controller.ServiceAccountReconciler{
+		Client:                    mgr.GetClient(),
+		AWS:                       aw,
+		Recorder:                  recorder,
+		OIDCIssuers:               issuers,
+		Clusters:                  opts.ClusterNames,
+		Audiences:                 opts.Audiences,
+		Tags:                      tags,
+		PermissionsBoundary:       opts.PermissionsBoundary,
+		RemovePermissionsBoundary: opts.RemovePermissionsBoundary,
+		RolePath:                  opts.RolePath,
+		PolicyPath:                opts.PolicyPath,
+		RoleDescription:           opts.RoleDescription,
+		MaxSessionDuration:        opts.MaxSessionDuration,
+		RolePrefix:                c.RolePrefix,
+		ResyncPeriod:              c.ResyncPeriod,
+	}
+	if err := saReconciler.SetupWithManager(mgr); err != nil {
+		return errors.Wrapf(err, "setting up service account controller")
+	}
+	if c.IAMServiceAccountRoles {
+		roleReconciler := &controller.IAMServiceAccountRoleReconciler{
+			Client:                    mgr.GetClient(),
+			AWS:                       aw,
+			Recorder:                  recorder,
+			OIDCIssuers:               issuers,
+			Clusters:                  opts.ClusterNames,
+			Audiences:                 opts.Audiences,
+			Tags:                      tags,
+			PermissionsBoundary:       opts.PermissionsBoundary,
+			RemovePermissionsBoundary: opts.RemovePermissionsBoundary,
+			RolePath:                  opts.RolePath,
+			PolicyPath:                opts.PolicyPath,
+			RoleDescription:           opts.RoleDescription,
+			MaxSessionDuration:        opts.MaxSessionDuration,
+			RolePrefix:                c.RolePrefix,
+			ResyncPeriod:              c.ResyncPeriod,
+		}
+		if err := roleReconciler.SetupWithManager(mgr); err != nil {
+			return errors.Wrapf(err, "setting up IAMServiceAccountRole controller")
+		}
+	}
+	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
+		return err
+	}
+	if err := mgr.AddReadyzCheck("readyz", healthz.Ping); err != nil {
+		return err
+	}
+	return mgr.Start(ctrl.SetupSignalHandler())
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"log"
+	"os"
+
+	"github.com/ldx/eks_iam_role/pkg/awswrapper"
+	"github.com/ldx/eks_iam_role/pkg/diff"
+	"github.com/pkg/errors"
+)
+
+type deleteCommand struct {
+	KeepPolicy bool `long:"keep-policy" description:"Only detach the policy from the role, don't delete it" env:"KEEP_POLICY"`
+}
+
+var deleteCmd deleteCommand
+
+func (c *deleteCommand) Execute(args []string) error {
+	if opts.RoleName == "" {
+		return errors.New("--role-name needs to be set")
+	}
+	policyName := opts.PolicyName
+	if policyName == "" {
+		policyName = opts.RoleName
+	}
+	if c.KeepPolicy {
+		policyName = ""
+	}
+	aw, err := awswrapper.New(opts.AWSRegion, opts.AWSEndpoint, opts.DryRun)
+	if err != nil {
+		return errors.Wrapf(err, "creating awswrapper")
+	}
+	result, err := aw.DeleteRole(opts.RoleName, policyName, opts.PolicyPath)
+	if err != nil {
+		return errors.Wrapf(err, "deleting role")
+	}
+	if opts.Output == outputJSON {
+		return writeJSON(os.Stdout, newResult(nil, result))
+	}
+	if opts.DryRun {
+		printPlan(os.Stdout, result.Changes, diff.ColorEnabled(os.Stdout))
+		return nil
+	}
+	log.Printf("Success")
+	return nil
+}
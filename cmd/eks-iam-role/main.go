@@ -1,32 +1,166 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/jessevdk/go-flags"
 	"github.com/ldx/eks_iam_role/pkg/awswrapper"
+	"github.com/ldx/eks_iam_role/pkg/diff"
+	"github.com/ldx/eks_iam_role/pkg/serviceaccount"
 )
 
 var opts struct {
-	RoleName       string `long:"role-name" description:"Name of role to ensure" env:"ROLE_NAME" required:"true"`
-	PolicyName     string `long:"policy-name" description:"Name of policy that will be ensured, by default it will be same as role name" env:"POLICY_NAME"`
-	PolicyFilePath string `long:"policy-file-path" description:"Path of policy JSON file" value-name:"FILE" env:"POLICY_FILE_PATH" required:"true"`
-	AWSRegion      string `long:"aws-region" description:"AWS region" env:"AWS_REGION" required:"true"`
-	AWSEndpoint    string `long:"aws-endpoint" description:"AWS endpoint URL" env:"AWS_ENDPOINT" default:""`
-	ClusterName    string `long:"cluster-name" description:"Get OIDC issuer from cluster for creating role, either cluster-name or oidc-issuer needs to be set" env:"CLUSTER_NAME"`
-	OIDCIssuer     string `long:"oidc-issuer" description:"Create role trust policy based on OIDC issuer for creating role, either cluster-name or oidc-issuer needs to be set" env:"OIDC_ISSUER"`
-	Namespace      string `long:"namespace" description:"Namespace of the service account for which an IAM role association will be created" env:"NAMESPACE" required:"true"`
-	ServiceAccount string `long:"service-account" description:"Name of service account for which an IAM role association will be created" env:"SERVICE_ACCOUNT" required:"true"`
+	RoleName                  string        `long:"role-name" description:"Name of role to ensure, required unless a command is given" env:"ROLE_NAME"`
+	RolePath                  string        `long:"role-path" description:"IAM path of roles, e.g. /eks/my-cluster/" value-name:"PATH" env:"ROLE_PATH"`
+	PolicyPath                string        `long:"policy-path" description:"IAM path of policies, also used for looking up policies given by name" value-name:"PATH" env:"POLICY_PATH"`
+	PolicyName                string        `long:"policy-name" description:"Name of policy that will be ensured, by default it will be same as role name" env:"POLICY_NAME"`
+	AttachPolicies            []string      `long:"attach-policy" description:"Name or ARN of another managed policy to attach to the role, e.g. arn:aws:iam::aws:policy/AmazonS3ReadOnlyAccess, can be repeated" value-name:"POLICY" env:"ATTACH_POLICIES" env-delim:","`
+	InlinePolicy              bool          `long:"inline-policy" description:"Put the policy into the role as an inline policy named --policy-name instead of creating a managed policy" env:"INLINE_POLICY"`
+	PolicyFilePath            string        `long:"policy-file-path" description:"Path of policy JSON file, required unless a command is given" value-name:"FILE" env:"POLICY_FILE_PATH"`
+	AWSRegion                 string        `long:"aws-region" description:"AWS region" env:"AWS_REGION" required:"true"`
+	AWSEndpoint               string        `long:"aws-endpoint" description:"AWS endpoint URL" env:"AWS_ENDPOINT" default:""`
+	DryRun                    bool          `long:"dry-run" description:"Only print the changes that would be made, without making them" env:"DRY_RUN"`
+	Output                    string        `long:"output" description:"Output format of the results" env:"OUTPUT" choice:"text" choice:"json" default:"text"`
+	ClusterNames              []string      `long:"cluster-name" description:"Get OIDC issuer from cluster for creating role, can be repeated; at least one cluster-name or oidc-issuer needs to be set" env:"CLUSTER_NAME" env-delim:","`
+	OIDCIssuers               []string      `long:"oidc-issuer" description:"Create role trust policy based on OIDC issuer for creating role, can be repeated; at least one cluster-name or oidc-issuer needs to be set" env:"OIDC_ISSUER" env-delim:","`
+	Namespaces                []string      `long:"namespace" description:"Namespace of the service account for which an IAM role association will be created, can be repeated once per service account, or given once for all service accounts; required unless a command is given" env:"NAMESPACE" env-delim:","`
+	ServiceAccounts           []string      `long:"service-account" description:"Name of service account for which an IAM role association will be created, can be repeated; namespaces and names can be glob patterns using * and ?; required unless a command is given" env:"SERVICE_ACCOUNT" env-delim:","`
+	AllowBroadPatterns        bool          `long:"allow-broad-patterns" description:"Allow service account patterns that match any namespace, e.g. '*' as namespace" env:"ALLOW_BROAD_PATTERNS"`
+	Audiences                 []string      `long:"audience" description:"Extra token audience allowed in the trust policy besides sts.amazonaws.com, can be repeated" env:"AUDIENCES" env-delim:","`
+	PermissionsBoundary       string        `long:"permissions-boundary" description:"Name or ARN of the managed policy set as permissions boundary of roles, the boundary of existing roles is kept if it is not set" value-name:"POLICY" env:"PERMISSIONS_BOUNDARY"`
+	RemovePermissionsBoundary bool          `long:"remove-permissions-boundary" description:"Remove the permissions boundary of existing roles" env:"REMOVE_PERMISSIONS_BOUNDARY"`
+	RoleDescription           string        `long:"role-description" description:"Description of roles, the description of existing roles is kept if it is not set" value-name:"TEXT" env:"ROLE_DESCRIPTION"`
+	MaxSessionDuration        time.Duration `long:"max-session-duration" description:"Maximum session duration of roles, between 1h and 12h; roles are created with 1h and the duration of existing roles is kept if it is not set" value-name:"DURATION" env:"MAX_SESSION_DURATION"`
+	ExclusivePolicies         bool          `long:"exclusive-policies" description:"Detach managed policies from roles that were not declared for them" env:"EXCLUSIVE_POLICIES"`
+	ExclusiveInlinePolicies   bool          `long:"exclusive-inline-policies" description:"Delete inline policies from roles that were not declared for them, their documents are lost" env:"EXCLUSIVE_INLINE_POLICIES"`
+	Tags                      []string      `long:"tag" description:"Tag as key=value set on roles and policies besides the ownership tags, can be repeated" value-name:"KEY=VALUE" env:"TAGS" env-delim:","`
+	// ServiceAccountManifest and AnnotateServiceAccounts are only supported
+	// for a single role.
+	ServiceAccountManifest  bool   `long:"service-account-manifest" description:"Print ServiceAccount manifests annotated with the role ARN instead of only the role ARN" env:"SERVICE_ACCOUNT_MANIFEST"`
+	AnnotateServiceAccounts bool   `long:"annotate-service-accounts" description:"Create the service accounts, or annotate existing ones, with the role ARN via the Kubernetes API" env:"ANNOTATE_SERVICE_ACCOUNTS"`
+	Kubeconfig              string `long:"kubeconfig" description:"Path of the kubeconfig file, by default $KUBECONFIG, ~/.kube/config or the in-cluster config is used" value-name:"FILE"`
+	KubeContext             string `long:"kube-context" description:"Kubeconfig context to use, by default the current context" env:"KUBE_CONTEXT"`
+	STSRegionalEndpoints    bool   `long:"sts-regional-endpoints" description:"Annotate service accounts to make pods use the regional STS endpoint" env:"STS_REGIONAL_ENDPOINTS"`
+	TokenExpiration         int64  `long:"token-expiration" description:"Annotate service accounts with the expiration of projected tokens in seconds, at least 600" value-name:"SECONDS" env:"TOKEN_EXPIRATION"`
+}
+
+// pairServiceAccounts pairs namespaces and service account names by position.
+// A single namespace is used for all service accounts.
+func pairServiceAccounts(namespaces, names []string) ([]awswrapper.ServiceAccount, error) {
+	if len(namespaces) != 1 && len(namespaces) != len(names) {
+		return nil, fmt.Errorf("either one --namespace or one per --service-account needs to be set, got %d namespaces for %d service accounts", len(namespaces), len(names))
+	}
+	serviceAccounts := make([]awswrapper.ServiceAccount, 0, len(names))
+	for i, name := range names {
+		namespace := namespaces[0]
+		if len(namespaces) > 1 {
+			namespace = namespaces[i]
+		}
+		serviceAccounts = append(serviceAccounts, awswrapper.ServiceAccount{
+			Namespace: namespace,
+			Name:      name,
+		})
+	}
+	return serviceAccounts, nil
+}
+
+// parseTags parses and validates the key=value pairs of --tag.
+func parseTags(pairs []string) (map[string]string, error) {
+	tags := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		i := strings.Index(pair, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid tag %q, expected key=value", pair)
+		}
+		tags[pair[:i]] = pair[i+1:]
+	}
+	if err := awswrapper.ValidateTags(tags); err != nil {
+		return nil, err
+	}
+	return tags, nil
+}
+
+// missingOptions returns the options required for ensuring a single role
+// that are not set.
+func missingOptions() []string {
+	var missing []string
+	if opts.RoleName == "" {
+		missing = append(missing, "--role-name")
+	}
+	if opts.PolicyFilePath == "" {
+		missing = append(missing, "--policy-file-path")
+	}
+	if len(opts.Namespaces) == 0 {
+		missing = append(missing, "--namespace")
+	}
+	if len(opts.ServiceAccounts) == 0 {
+		missing = append(missing, "--service-account")
+	}
+	return missing
+}
+
+// serviceAccountOptions returns the annotations of the service accounts,
+// rendered or annotated.
+func serviceAccountOptions(roleARN string) serviceaccount.Options {
+	return serviceaccount.Options{
+		RoleARN:              roleARN,
+		STSRegionalEndpoints: opts.STSRegionalEndpoints,
+		TokenExpiration:      opts.TokenExpiration,
+	}
+}
+
+// annotateServiceAccounts ensures the service accounts are annotated with the
+// role ARN.
+func annotateServiceAccounts(annotator *serviceaccount.Annotator, roleARN string, serviceAccounts []awswrapper.ServiceAccount) []awswrapper.Change {
+	if roleARN == "" {
+		log.Printf("Role would be created, skipping service accounts")
+		return nil
+	}
+	annotations := serviceAccountOptions(roleARN)
+	var changes []awswrapper.Change
+	for _, sa := range serviceAccounts {
+		saChanges, err := annotator.Ensure(context.Background(), sa, annotations)
+		if err != nil {
+			log.Fatalf("Annotating service account: %v", err)
+		}
+		changes = append(changes, saChanges...)
+	}
+	return changes
 }
 
 func main() {
-	if _, err := flags.Parse(&opts); err != nil {
+	parser := flags.NewParser(&opts, flags.Default)
+	parser.SubcommandsOptional = true
+	if _, err := parser.AddCommand("apply", "Apply a manifest", "Ensure all roles and policies declared in a YAML or JSON manifest file", &applyCmd); err != nil {
+		log.Fatalf("Adding apply command: %v", err)
+	}
+	if _, err := parser.AddCommand("delete", "Delete a role", "Delete the role and its policy, if they were created by eks-iam-role and the policy is not attached elsewhere", &deleteCmd); err != nil {
+		log.Fatalf("Adding delete command: %v", err)
+	}
+	if _, err := parser.AddCommand("controller", "Run as a Kubernetes controller", "Ensure a role for every service account annotated with a policy", &controllerCmd); err != nil {
+		log.Fatalf("Adding controller command: %v", err)
+	}
+	if _, err := parser.Parse(); err != nil {
 		os.Exit(1)
 	}
-	if opts.OIDCIssuer == "" && opts.ClusterName == "" {
-		log.Fatal("Either --oidc-issuer or --cluster-name need to be set")
+	if parser.Active != nil {
+		// The command has already been executed.
+		return
+	}
+	if missing := missingOptions(); len(missing) > 0 {
+		log.Fatalf("Required flags not specified: %s", strings.Join(missing, ", "))
+	}
+	if len(opts.OIDCIssuers) == 0 && len(opts.ClusterNames) == 0 {
+		log.Fatal("At least one --oidc-issuer or --cluster-name needs to be set")
+	}
+	if err := serviceaccount.ValidateTokenExpiration(opts.TokenExpiration); err != nil {
+		log.Fatalf("Invalid --token-expiration: %v", err)
 	}
 	if opts.PolicyName == "" {
 		opts.PolicyName = opts.RoleName
@@ -35,24 +169,110 @@ func main() {
 	if err != nil {
 		log.Fatalf("Reading policy file %q: %v", opts.PolicyFilePath, err)
 	}
-	aw, err := awswrapper.New(opts.AWSRegion, opts.AWSEndpoint)
+	aw, err := awswrapper.New(opts.AWSRegion, opts.AWSEndpoint, opts.DryRun)
 	if err != nil {
 		log.Fatalf("Creating awswrapper: %v", err)
 	}
-	trustPolicy := ""
-	if opts.ClusterName != "" {
-		trustPolicy, err = aw.TrustPolicyFromCluster(opts.ClusterName, opts.Namespace, opts.ServiceAccount)
+	serviceAccounts, err := pairServiceAccounts(opts.Namespaces, opts.ServiceAccounts)
+	if err != nil {
+		log.Fatalf("Getting service accounts: %v", err)
+	}
+	userTags, err := parseTags(opts.Tags)
+	if err != nil {
+		log.Fatalf("Parsing tags: %v", err)
+	}
+	tags := awswrapper.MergeTags(awswrapper.OwnershipTags(opts.ClusterNames, serviceAccounts), userTags)
+	if opts.ServiceAccountManifest || opts.AnnotateServiceAccounts {
+		for _, sa := range serviceAccounts {
+			if sa.IsPattern() {
+				log.Fatalf("Service account pattern %s can't be rendered or annotated", sa)
+			}
+		}
+	}
+	var annotator *serviceaccount.Annotator
+	if opts.AnnotateServiceAccounts {
+		client, err := serviceaccount.NewClient(opts.Kubeconfig, opts.KubeContext)
 		if err != nil {
-			log.Fatalf("Getting trust policy: %v", err)
+			log.Fatalf("Creating Kubernetes client: %v", err)
 		}
-	} else {
-		trustPolicy = aw.TrustPolicyFromOIDCIssuer(opts.OIDCIssuer, opts.Namespace, opts.ServiceAccount)
+		annotator = serviceaccount.NewAnnotator(client, opts.DryRun)
+	}
+	trustOpts := awswrapper.TrustOptions{
+		ServiceAccounts:    serviceAccounts,
+		Audiences:          opts.Audiences,
+		AllowBroadPatterns: opts.AllowBroadPatterns,
 	}
-	if err = aw.EnsurePolicy(opts.PolicyName, buf); err != nil {
-		log.Fatalf("Ensuring policy: %v", err)
+	clusterIssuers, err := aw.OIDCIssuersFromClusters(opts.ClusterNames)
+	if err != nil {
+		log.Fatalf("Getting OIDC issuers: %v", err)
 	}
-	if err = aw.EnsureRole(opts.RoleName, opts.PolicyName, trustPolicy); err != nil {
+	trustPolicy, err := aw.TrustPolicyFromOIDCIssuers(append(opts.OIDCIssuers, clusterIssuers...), trustOpts)
+	if err != nil {
+		log.Fatalf("Getting trust policy: %v", err)
+	}
+	roleOpts := awswrapper.RoleOptions{
+		Path:                      opts.RolePath,
+		PolicyPath:                opts.PolicyPath,
+		Tags:                      tags,
+		PermissionsBoundary:       opts.PermissionsBoundary,
+		RemovePermissionsBoundary: opts.RemovePermissionsBoundary,
+		Description:               opts.RoleDescription,
+		MaxSessionDuration:        opts.MaxSessionDuration,
+		ExclusivePolicies:         opts.ExclusivePolicies,
+		ExclusiveInlinePolicies:   opts.ExclusiveInlinePolicies,
+	}
+	policies := opts.AttachPolicies
+	var policyResult *awswrapper.EnsureResult
+	if opts.InlinePolicy {
+		roleOpts.InlinePolicies = map[string][]byte{opts.PolicyName: buf}
+	} else {
+		policyResult, err = aw.EnsurePolicy(opts.PolicyName, buf, awswrapper.PolicyOptions{
+			Path: opts.PolicyPath,
+			Tags: tags,
+		})
+		if err != nil {
+			log.Fatalf("Ensuring policy: %v", err)
+		}
+		policies = append([]string{opts.PolicyName}, policies...)
+	}
+	roleResult, err := aw.EnsureRole(opts.RoleName, policies, trustPolicy, roleOpts)
+	if err != nil {
 		log.Fatalf("Ensuring role: %v", err)
 	}
+	var saManifest []byte
+	// The role ARN is only unknown if the role would be created in dry-run
+	// mode.
+	if opts.ServiceAccountManifest && roleResult.ARN != "" {
+		saManifest, err = serviceaccount.Render(serviceAccountOptions(roleResult.ARN), serviceAccounts)
+		if err != nil {
+			log.Fatalf("Rendering service account manifest: %v", err)
+		}
+	}
+	var saChanges []awswrapper.Change
+	if annotator != nil {
+		saChanges = annotateServiceAccounts(annotator, roleResult.ARN, serviceAccounts)
+	}
+	if opts.Output == outputJSON {
+		result := newResult(policyResult, roleResult, saChanges...)
+		result.ServiceAccountManifest = string(saManifest)
+		if err := writeJSON(os.Stdout, result); err != nil {
+			log.Fatalf("Writing output: %v", err)
+		}
+		return
+	}
+	if opts.DryRun {
+		var changes []awswrapper.Change
+		if policyResult != nil {
+			changes = append(changes, policyResult.Changes...)
+		}
+		changes = append(changes, roleResult.Changes...)
+		printPlan(os.Stdout, append(changes, saChanges...), diff.ColorEnabled(os.Stdout))
+		return
+	}
 	log.Printf("Success")
+	if opts.ServiceAccountManifest {
+		os.Stdout.Write(saManifest)
+	} else {
+		fmt.Println(roleResult.ARN)
+	}
 }
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/ldx/eks_iam_role/pkg/awswrapper"
+)
+
+const (
+	outputText = "text"
+	outputJSON = "json"
+)
+
+// result is the machine readable outcome of ensuring a policy, a role, or
+// both.
+type result struct {
+	RoleARN              string              `json:"roleArn,omitempty"`
+	PolicyARN            string              `json:"policyArn,omitempty"`
+	PolicyVersion        string              `json:"policyVersion,omitempty"`
+	PolicyVersionCreated bool                `json:"policyVersionCreated"`
+	Changed              bool                `json:"changed"`
+	DryRun               bool                `json:"dryRun"`
+	Actions              []awswrapper.Change `json:"actions"`
+	// ServiceAccountManifest is set if --service-account-manifest is.
+	ServiceAccountManifest string `json:"serviceAccountManifest,omitempty"`
+}
+
+// newResult returns the result of ensuring the policy and the role, either of
+// which can be nil, and other changes, e.g. to service accounts.
+func newResult(policyResult, roleResult *awswrapper.EnsureResult, other ...awswrapper.Change) result {
+	r := result{
+		DryRun:  opts.DryRun,
+		Actions: []awswrapper.Change{},
+	}
+	if policyResult != nil {
+		r.PolicyARN = policyResult.ARN
+		r.PolicyVersion = policyResult.VersionID
+		r.PolicyVersionCreated = policyResult.VersionCreated
+		r.Actions = append(r.Actions, policyResult.Changes...)
+	}
+	if roleResult != nil {
+		r.RoleARN = roleResult.ARN
+		r.Actions = append(r.Actions, roleResult.Changes...)
+	}
+	r.Actions = append(r.Actions, other...)
+	r.Changed = len(r.Actions) > 0
+	return r
+}
+
+func writeJSON(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/ldx/eks_iam_role/pkg/awswrapper"
+	"github.com/ldx/eks_iam_role/pkg/diff"
+)
+
+var changeSymbols = map[awswrapper.ChangeAction]string{
+	awswrapper.ChangeCreate: "+",
+	awswrapper.ChangeUpdate: "~",
+	awswrapper.ChangeAttach: "+",
+	awswrapper.ChangeDelete: "-",
+	awswrapper.ChangeDetach: "-",
+}
+
+// printPlan prints the changes that would be made, one line per change. For
+// policy documents the diff between the current and desired document follows,
+// other values are printed as they are.
+func printPlan(w io.Writer, changes []awswrapper.Change, color bool) {
+	if len(changes) == 0 {
+		fmt.Fprintln(w, "No changes")
+		return
+	}
+	for _, c := range changes {
+		fmt.Fprintf(w, "%s %s %s %s\n", changeSymbols[c.Action], c.Action, c.Resource, c.Name)
+		if isDocument(c.Before) || isDocument(c.After) {
+			d := diff.Unified(c.Before, c.After, "current", "desired", color)
+			for _, line := range strings.Split(strings.TrimSuffix(d, "\n"), "\n") {
+				fmt.Fprintf(w, "    %s\n", line)
+			}
+			continue
+		}
+		if c.Before != "" {
+			fmt.Fprintf(w, "    before: %s\n", c.Before)
+		}
+		if c.After != "" {
+			fmt.Fprintf(w, "    after: %s\n", c.After)
+		}
+	}
+}
+
+func isDocument(value string) bool {
+	return strings.HasPrefix(value, "{")
+}
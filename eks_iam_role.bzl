@@ -36,6 +36,8 @@ def _eks_iam_role(ctx):
         args.extend(["--cluster-name", ctx.attr.cluster_name])
     if ctx.attr.oidc_issuer:
         args.extend(["--oidc-issuer", ctx.attr.oidc_issuer])
+    for audience in ctx.attr.audiences:
+        args.extend(["--audience", audience])
 
     ctx.actions.write(
         output = ctx.outputs.executable,
@@ -62,6 +64,7 @@ eks_iam_role = rule(
         "oidc_issuer": attr.string(),
         "namespace": attr.string(mandatory=True),
         "service_account": attr.string(mandatory=True),
+        "audiences": attr.string_list(),
         "tool": attr.label(
             default = Label("//cmd/eks-iam-role:eks-iam-role"),
             executable = True,
@@ -0,0 +1,51 @@
+package v1alpha1
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestIAMServiceAccountRoleDeepCopy(t *testing.T) {
+	now := metav1.Now()
+	role := &IAMServiceAccountRole{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   "my-namespace",
+			Name:        "my-role",
+			Annotations: map[string]string{"a": "b"},
+		},
+		Spec: IAMServiceAccountRoleSpec{
+			PolicyConfigMapRef: &corev1.ConfigMapKeySelector{
+				LocalObjectReference: corev1.LocalObjectReference{Name: "my-policy"},
+				Key:                  "policy.json",
+			},
+			ServiceAccounts: []string{"my-app"},
+			Clusters:        []string{"my-cluster"},
+		},
+		Status: IAMServiceAccountRoleStatus{
+			LastSyncTime: &now,
+			Conditions: []metav1.Condition{
+				{Type: ConditionReady, Status: metav1.ConditionTrue},
+			},
+		},
+	}
+	list := &IAMServiceAccountRoleList{Items: []IAMServiceAccountRole{*role}}
+	listCopy := list.DeepCopyObject().(*IAMServiceAccountRoleList)
+	assert.Equal(t, list, listCopy)
+	c := &listCopy.Items[0]
+	c.Annotations["a"] = "c"
+	c.Spec.PolicyConfigMapRef.Key = "other.json"
+	c.Spec.ServiceAccounts[0] = "other-app"
+	c.Spec.Clusters[0] = "other-cluster"
+	c.Status.LastSyncTime.Time = now.Add(1)
+	c.Status.Conditions[0].Status = metav1.ConditionFalse
+	assert.Equal(t, role, &list.Items[0])
+	assert.Equal(t, "b", role.Annotations["a"])
+	assert.Equal(t, "policy.json", role.Spec.PolicyConfigMapRef.Key)
+	assert.Equal(t, []string{"my-app"}, role.Spec.ServiceAccounts)
+	assert.Equal(t, []string{"my-cluster"}, role.Spec.Clusters)
+	assert.Equal(t, now, *role.Status.LastSyncTime)
+	assert.Equal(t, metav1.ConditionTrue, role.Status.Conditions[0].Status)
+}
@@ -0,0 +1,22 @@
+// Package v1alpha1 contains the eks-iam-role.ldx.github.com/v1alpha1 API.
+// The deep copy functions and the CRD in config/crd are generated from the
+// types and their markers, run go generate after changing them.
+// +kubebuilder:object:generate=true
+// +groupName=eks-iam-role.ldx.github.com
+package v1alpha1
+
+//go:generate go run sigs.k8s.io/controller-tools/cmd/controller-gen@v0.9.2 object crd:crdVersions=v1 paths=. output:crd:artifacts:config=../../../config/crd
+
+import (
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/scheme"
+)
+
+var (
+	GroupVersion = schema.GroupVersion{Group: "eks-iam-role.ldx.github.com", Version: "v1alpha1"}
+
+	SchemeBuilder = &scheme.Builder{GroupVersion: GroupVersion}
+
+	// AddToScheme adds the types of this API to a scheme.
+	AddToScheme = SchemeBuilder.AddToScheme
+)
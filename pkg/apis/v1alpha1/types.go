@@ -0,0 +1,79 @@
+package v1alpha1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	ConditionReady = "Ready"
+)
+
+// IAMServiceAccountRoleSpec declares a role and its policy for service
+// accounts in the namespace of the IAMServiceAccountRole.
+type IAMServiceAccountRoleSpec struct {
+	// RoleName is the name of the role, by default the name of the
+	// IAMServiceAccountRole. The controller prefixes it with its role
+	// prefix and the namespace.
+	// +kubebuilder:validation:MaxLength=64
+	// +kubebuilder:validation:Pattern=`^[\w+=,.@-]+$`
+	// +optional
+	RoleName string `json:"roleName,omitempty"`
+	// PolicyDocument is the JSON policy document of the role's policy.
+	// Either PolicyDocument or PolicyConfigMapRef has to be set.
+	// +optional
+	PolicyDocument string `json:"policyDocument,omitempty"`
+	// PolicyConfigMapRef selects the key of a ConfigMap in the namespace of
+	// the IAMServiceAccountRole with the policy document.
+	// +optional
+	PolicyConfigMapRef *corev1.ConfigMapKeySelector `json:"policyConfigMapRef,omitempty"`
+	// ServiceAccounts are the names of the service accounts allowed to
+	// assume the role.
+	// +kubebuilder:validation:MinItems=1
+	ServiceAccounts []string `json:"serviceAccounts"`
+	// Clusters are the EKS clusters whose OIDC issuers are trusted, by
+	// default the ones the controller trusts.
+	// +optional
+	Clusters []string `json:"clusters,omitempty"`
+}
+
+// IAMServiceAccountRoleStatus is the observed state of the role and policy.
+type IAMServiceAccountRoleStatus struct {
+	RoleARN       string `json:"roleArn,omitempty"`
+	PolicyARN     string `json:"policyArn,omitempty"`
+	PolicyVersion string `json:"policyVersion,omitempty"`
+	// LastSyncTime is when the role and policy were last ensured
+	// successfully.
+	LastSyncTime       *metav1.Time `json:"lastSyncTime,omitempty"`
+	ObservedGeneration int64        `json:"observedGeneration,omitempty"`
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// IAMServiceAccountRole is an IAM role and policy for service accounts.
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:shortName=isar
+// +kubebuilder:printcolumn:name="Role ARN",type=string,JSONPath=`.status.roleArn`
+// +kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+// +kubebuilder:printcolumn:name="Last Sync",type=date,JSONPath=`.status.lastSyncTime`
+type IAMServiceAccountRole struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   IAMServiceAccountRoleSpec   `json:"spec,omitempty"`
+	Status IAMServiceAccountRoleStatus `json:"status,omitempty"`
+}
+
+// IAMServiceAccountRoleList is a list of IAMServiceAccountRoles.
+// +kubebuilder:object:root=true
+type IAMServiceAccountRoleList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []IAMServiceAccountRole `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&IAMServiceAccountRole{}, &IAMServiceAccountRoleList{})
+}
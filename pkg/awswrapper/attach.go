@@ -0,0 +1,114 @@
+package awswrapper
+
+import (
+	"log"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/pkg/errors"
+)
+
+var (
+	policyNameRegexp = regexp.MustCompile(`^[\w+=,.@-]{1,128}$`)
+	policyARNRegexp  = regexp.MustCompile(`^arn:aws(-[a-z]+)*:iam::(aws|[0-9]{12}):policy/([\w+=,.@-]+/)*[\w+=,.@-]{1,128}$`)
+)
+
+// policyARN resolves a policy reference to an ARN. A reference is either the
+// name of a customer managed policy in the account, or the ARN of any managed
+// policy, e.g. "arn:aws:iam::aws:policy/AmazonS3ReadOnlyAccess" for an AWS
+// managed one. Names are looked up under path.
+func (a *awsWrapper) policyARN(policy, path string) (string, error) {
+	switch {
+	case strings.HasPrefix(policy, "arn:"):
+		if !policyARNRegexp.MatchString(policy) {
+			return "", errors.Errorf("invalid policy ARN %q", policy)
+		}
+		return policy, nil
+	case policyNameRegexp.MatchString(policy):
+		return aws.StringValue(a.arn("policy", path, policy)), nil
+	default:
+		return "", errors.Errorf("invalid policy name %q", policy)
+	}
+}
+
+// policyARNs resolves policy references to ARNs, see policyARN. Duplicates
+// are dropped.
+func (a *awsWrapper) policyARNs(policies []string, path string) ([]string, error) {
+	seen := make(map[string]bool, len(policies))
+	var arns []string
+	for _, policy := range policies {
+		arn, err := a.policyARN(policy, path)
+		if err != nil {
+			return nil, err
+		}
+		if seen[arn] {
+			continue
+		}
+		seen[arn] = true
+		arns = append(arns, arn)
+	}
+	return arns, nil
+}
+
+// ensureAttachedPolicies attaches the policies to the role, unless they are
+// attached already. Other attached policies are detached if exclusive is set.
+func (a *awsWrapper) ensureAttachedPolicies(roleName string, policyARNs []string, roleExists, exclusive bool, result *EnsureResult) error {
+	attached := make(map[string]bool)
+	var undeclared []*iam.AttachedPolicy
+	// In dry-run mode the role might not have been created, and there are no
+	// attached policies to look up.
+	if roleExists {
+		declared := make(map[string]bool, len(policyARNs))
+		for _, arn := range policyARNs {
+			declared[arn] = true
+		}
+		listResult, err := a.iam.ListAttachedRolePolicies(&iam.ListAttachedRolePoliciesInput{
+			RoleName: aws.String(roleName),
+		})
+		if err != nil {
+			return errors.Wrapf(err, "list role %s attached policies", roleName)
+		}
+		for _, policy := range listResult.AttachedPolicies {
+			arn := aws.StringValue(policy.PolicyArn)
+			if declared[arn] {
+				log.Printf("Found attached policy %s for role %s", arn, roleName)
+				attached[arn] = true
+				continue
+			}
+			undeclared = append(undeclared, policy)
+		}
+	}
+	for _, arn := range policyARNs {
+		if attached[arn] {
+			continue
+		}
+		if !a.dryRun {
+			_, err := a.iam.AttachRolePolicy(&iam.AttachRolePolicyInput{
+				PolicyArn: aws.String(arn),
+				RoleName:  aws.String(roleName),
+			})
+			if err != nil {
+				return errors.Wrapf(err, "attach policy %s to role %s", arn, roleName)
+			}
+			log.Printf("Attached policy %s to role %s", arn, roleName)
+		}
+		result.add(Change{
+			Action:   ChangeAttach,
+			Resource: ResourceRolePolicyAttachment,
+			Name:     roleName,
+			After:    arn,
+		})
+	}
+	for _, policy := range undeclared {
+		if !exclusive {
+			log.Printf("Keeping policy %s attached to role %s, it was not declared for the role", aws.StringValue(policy.PolicyName), roleName)
+			continue
+		}
+		if err := a.detachRolePolicy(roleName, policy, result); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package awswrapper
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPolicyARNs(t *testing.T) {
+	testCases := []struct {
+		policies []string
+		arns     []string
+		err      bool
+	}{
+		{
+			policies: nil,
+			arns:     nil,
+		},
+		{
+			policies: []string{"my-policy", "arn:aws:iam::aws:policy/AmazonS3ReadOnlyAccess", "arn:aws:iam::123456789012:policy/my-policy"},
+			arns:     []string{"arn:aws:iam::123456789012:policy/my-policy", "arn:aws:iam::aws:policy/AmazonS3ReadOnlyAccess"},
+		},
+		{
+			policies: []string{"arn:aws:iam::aws:policy/service-role/AmazonEKSFargatePodExecutionRolePolicy", "arn:aws-cn:iam::210987654321:policy/other-policy"},
+			arns:     []string{"arn:aws:iam::aws:policy/service-role/AmazonEKSFargatePodExecutionRolePolicy", "arn:aws-cn:iam::210987654321:policy/other-policy"},
+		},
+		{
+			policies: []string{"my policy"},
+			err:      true,
+		},
+		{
+			policies: []string{""},
+			err:      true,
+		},
+		{
+			policies: []string{"arn:aws:iam::123456789012:role/my-role"},
+			err:      true,
+		},
+		{
+			policies: []string{"arn:aws:s3:::my-bucket"},
+			err:      true,
+		},
+	}
+	aw := awsWrapper{accountID: "123456789012"}
+	for _, tc := range testCases {
+		arns, err := aw.policyARNs(tc.policies, "/")
+		if tc.err {
+			assert.Error(t, err, "%v", tc.policies)
+			continue
+		}
+		assert.NoError(t, err, "%v", tc.policies)
+		assert.Equal(t, tc.arns, arns)
+	}
+}
+
+func TestEnsureAttachedPolicies(t *testing.T) {
+	mock := mutationErrors(&mockedIAMAPI{
+		listAttachedRolePoliciesOut: &iam.ListAttachedRolePoliciesOutput{
+			AttachedPolicies: []*iam.AttachedPolicy{
+				{
+					PolicyArn:  aws.String("arn:aws:iam::123456789012:policy/my-policy"),
+					PolicyName: aws.String("my-policy"),
+				},
+				{
+					PolicyArn:  aws.String("arn:aws:iam::aws:policy/ReadOnlyAccess"),
+					PolicyName: aws.String("ReadOnlyAccess"),
+				},
+			},
+		},
+	})
+	policyARNs := []string{
+		"arn:aws:iam::123456789012:policy/my-policy",
+		"arn:aws:iam::aws:policy/AmazonS3ReadOnlyAccess",
+	}
+	aw := awsWrapper{accountID: "123456789012", dryRun: true, iam: mock}
+	result := &EnsureResult{}
+	assert.NoError(t, aw.ensureAttachedPolicies("my-role", policyARNs, true, true, result))
+	assert.Equal(t, []Change{
+		{
+			Action:   ChangeAttach,
+			Resource: ResourceRolePolicyAttachment,
+			Name:     "my-role",
+			After:    "arn:aws:iam::aws:policy/AmazonS3ReadOnlyAccess",
+		},
+		{
+			Action:   ChangeDetach,
+			Resource: ResourceRolePolicyAttachment,
+			Name:     "my-role",
+			Before:   "arn:aws:iam::aws:policy/ReadOnlyAccess",
+		},
+	}, result.Changes)
+
+	// The role would be created, every policy would be attached.
+	result = &EnsureResult{}
+	assert.NoError(t, aw.ensureAttachedPolicies("my-role", policyARNs, false, true, result))
+	assert.Len(t, result.Changes, 2)
+	for _, c := range result.Changes {
+		assert.Equal(t, ChangeAttach, c.Action)
+	}
+
+	aw.dryRun = false
+	assert.Error(t, aw.ensureAttachedPolicies("my-role", policyARNs, true, false, &EnsureResult{}))
+}
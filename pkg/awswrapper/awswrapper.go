@@ -5,6 +5,9 @@ import (
 	"fmt"
 	"log"
 	"net/url"
+	"sort"
+	"sync"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
@@ -18,49 +21,65 @@ import (
 	"github.com/pkg/errors"
 )
 
-const (
-	trustTemplate = `{
-  "Version": "2012-10-17",
-  "Statement": [
-    {
-      "Effect": "Allow",
-      "Principal": {
-        "Federated": "arn:aws:iam::%s:oidc-provider/%s"
-      },
-      "Action": "sts:AssumeRoleWithWebIdentity",
-      "Condition": {
-        "StringEquals": {
-          "%s:sub": "system:serviceaccount:%s:%s"
-        }
-      }
-    }
-  ]
-}`
-)
-
 type AWSWrapper interface {
-	EnsurePolicy(policyName string, policyDocument []byte) error
-	EnsureRole(roleName string, policyName, trustPolicy string) error
-	TrustPolicyFromCluster(clusterName, namespace, serviceAccount string) (string, error)
-	TrustPolicyFromOIDCIssuer(issuer, namespace, serviceAccount string) string
+	EnsurePolicy(policyName string, policyDocument []byte, opts PolicyOptions) (*EnsureResult, error)
+	EnsureRole(roleName string, policies []string, trustPolicy string, opts RoleOptions) (*EnsureResult, error)
+	OIDCIssuersFromClusters(clusterNames []string) ([]string, error)
+	TrustPolicyFromOIDCIssuers(issuers []string, opts TrustOptions) (string, error)
+	DeleteRole(roleName, policyName, policyPath string) (*EnsureResult, error)
 }
 
-type awsWrapper struct {
-	accountID string
-	iam       iamiface.IAMAPI
-	eks       eksiface.EKSAPI
-	sts       stsiface.STSAPI
+// PolicyOptions configures a customer managed policy besides its document.
+type PolicyOptions struct {
+	// Path of the policy, e.g. "/eks/my-cluster/". The path of existing
+	// policies can't be changed.
+	Path string
+	// Tags are set on the policy in addition to the managed-by tag.
+	Tags map[string]string
 }
 
-type policyStatement struct {
-	Effect   string
-	Action   []string
-	Resource string
+// RoleOptions configures a role besides its trust policy and attached policy.
+type RoleOptions struct {
+	// Path of the role, see PolicyOptions.
+	Path string
+	// PolicyPath is the path of policies given by name, including the
+	// permissions boundary.
+	PolicyPath string
+	// Tags are set on the role in addition to the managed-by tag, e.g. the
+	// ones returned by OwnershipTags.
+	Tags map[string]string
+	// PermissionsBoundary is the name or ARN of the managed policy used as
+	// the permissions boundary of the role. If it is empty, the boundary of
+	// an existing role is left alone.
+	PermissionsBoundary string
+	// RemovePermissionsBoundary removes the permissions boundary of an
+	// existing role. It can't be combined with PermissionsBoundary.
+	RemovePermissionsBoundary bool
+	// Description of the role. If it is empty, the description of an
+	// existing role is left alone.
+	Description string
+	// MaxSessionDuration of the role, between 1h and 12h. If it is zero,
+	// roles are created with the IAM default of 1h, and the duration of an
+	// existing role is left alone.
+	MaxSessionDuration time.Duration
+	// InlinePolicies are put into the role as inline policies, by name.
+	InlinePolicies map[string][]byte
+	// ExclusivePolicies detaches all managed policies from the role that
+	// were not passed to EnsureRole, e.g. the old policy after a rename.
+	ExclusivePolicies bool
+	// ExclusiveInlinePolicies deletes the inline policies of the role that
+	// are not in InlinePolicies. Unlike detaching, deleting an inline
+	// policy loses its document, so it is a separate option.
+	ExclusiveInlinePolicies bool
 }
 
-type policyDocument struct {
-	Version   string
-	Statement []policyStatement
+type awsWrapper struct {
+	accountID string
+	// dryRun disables all mutating IAM calls. Changes are only reported.
+	dryRun bool
+	iam    iamiface.IAMAPI
+	eks    eksiface.EKSAPI
+	sts    stsiface.STSAPI
 }
 
 func isNoSuchEntityError(err error) bool {
@@ -75,19 +94,26 @@ func isNoSuchEntityError(err error) bool {
 	return false
 }
 
-func cleanPolicy(buf []byte) (string, error) {
-	var doc policyDocument
-	if err := json.Unmarshal(buf, &doc); err != nil {
-		return "", err
+func isEntityAlreadyExistsError(err error) bool {
+	if awsErr, ok := err.(awserr.Error); ok {
+		return awsErr.Code() == iam.ErrCodeEntityAlreadyExistsException
 	}
-	policy, err := json.Marshal(&doc)
+	return false
+}
+
+// decodePolicyDocument parses a URL encoded policy document, as returned by
+// the IAM API.
+func decodePolicyDocument(encoded string) (*PolicyDocument, error) {
+	decoded, err := url.QueryUnescape(encoded)
 	if err != nil {
-		return "", err
+		return nil, errors.Wrapf(err, "decoding policy document")
 	}
-	return string(policy), nil
+	return ParsePolicyDocument([]byte(decoded))
 }
 
-func New(region, endpoint string) (AWSWrapper, error) {
+// New returns an AWSWrapper. In dry-run mode it only makes read calls, and
+// the changes it would make are reported in the EnsureResults.
+func New(region, endpoint string, dryRun bool) (AWSWrapper, error) {
 	sess, err := session.NewSession(&aws.Config{
 		Region:   aws.String(region),
 		Endpoint: aws.String(endpoint),
@@ -96,9 +122,10 @@ func New(region, endpoint string) (AWSWrapper, error) {
 		return nil, err
 	}
 	a := &awsWrapper{
-		iam: iam.New(sess),
-		eks: eks.New(sess),
-		sts: sts.New(sess),
+		dryRun: dryRun,
+		iam:    iam.New(sess),
+		eks:    eks.New(sess),
+		sts:    sts.New(sess),
 	}
 	if err := a.ensureAccountID(); err != nil {
 		return nil, err
@@ -106,8 +133,10 @@ func New(region, endpoint string) (AWSWrapper, error) {
 	return a, nil
 }
 
-func (a *awsWrapper) arn(resourceType, resourceName string) *string {
-	return aws.String(fmt.Sprintf("arn:aws:iam::%s:%s/%s", a.accountID, resourceType, resourceName))
+// arn returns the ARN of an IAM resource under path, which has to be
+// normalized.
+func (a *awsWrapper) arn(resourceType, path, resourceName string) *string {
+	return aws.String(fmt.Sprintf("arn:aws:iam::%s:%s%s%s", a.accountID, resourceType, path, resourceName))
 }
 
 func (a *awsWrapper) ensureAccountID() error {
@@ -122,135 +151,339 @@ func (a *awsWrapper) ensureAccountID() error {
 	return nil
 }
 
-func (a *awsWrapper) TrustPolicyFromOIDCIssuer(issuer, namespace, serviceAccount string) string {
-	return fmt.Sprintf(trustTemplate, a.accountID, issuer, issuer, namespace, serviceAccount)
+// TrustPolicyFromOIDCIssuers returns a trust policy for the service accounts,
+// with one statement per OIDC provider. Tokens have to be issued for the
+// default audience sts.amazonaws.com or for one of the extra audiences.
+func (a *awsWrapper) TrustPolicyFromOIDCIssuers(issuers []string, opts TrustOptions) (string, error) {
+	if len(issuers) == 0 {
+		return "", errors.New("no OIDC issuers")
+	}
+	subjects, operator, err := opts.subjects()
+	if err != nil {
+		return "", err
+	}
+	normalized := make(map[string]bool)
+	for _, issuer := range issuers {
+		issuer, err := NormalizeOIDCIssuer(issuer)
+		if err != nil {
+			return "", err
+		}
+		normalized[issuer] = true
+	}
+	sorted := make([]string, 0, len(normalized))
+	for issuer := range normalized {
+		sorted = append(sorted, issuer)
+	}
+	sort.Strings(sorted)
+	builder := NewTrustPolicyBuilder()
+	for _, issuer := range sorted {
+		builder.AddWebIdentity(
+			aws.StringValue(a.arn("oidc-provider", "/", issuer)),
+			TrustCondition{
+				Operator: operator,
+				Key:      issuer + ":sub",
+				Values:   subjects,
+			},
+			TrustCondition{
+				Operator: "StringEquals",
+				Key:      issuer + ":aud",
+				Values:   trustAudiences(opts.Audiences),
+			},
+		)
+	}
+	return builder.Build()
+}
+
+// OIDCIssuersFromClusters looks up the OIDC issuers of the clusters
+// concurrently. The issuers are returned in the order of the clusters.
+func (a *awsWrapper) OIDCIssuersFromClusters(clusterNames []string) ([]string, error) {
+	issuers := make([]string, len(clusterNames))
+	errs := make([]error, len(clusterNames))
+	var wg sync.WaitGroup
+	for i := range clusterNames {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			issuers[i], errs[i] = a.oidcIssuerFromCluster(clusterNames[i])
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return issuers, nil
 }
 
-func (a *awsWrapper) TrustPolicyFromCluster(clusterName, namespace, serviceAccount string) (string, error) {
+func (a *awsWrapper) oidcIssuerFromCluster(clusterName string) (string, error) {
 	describeClusterResult, err := a.eks.DescribeCluster(&eks.DescribeClusterInput{
 		Name: aws.String(clusterName),
 	})
 	if err != nil {
-		return "", errors.Wrapf(err, "describe cluster")
+		return "", errors.Wrapf(err, "describe cluster %s", clusterName)
 	}
 	if describeClusterResult.Cluster == nil ||
 		describeClusterResult.Cluster.Identity == nil ||
 		describeClusterResult.Cluster.Identity.Oidc == nil {
-		return "", errors.Wrapf(err, "describe cluster missing OIDC information")
+		return "", errors.Errorf("describe cluster %s missing OIDC information", clusterName)
 	}
-	issuer := aws.StringValue(describeClusterResult.Cluster.Identity.Oidc.Issuer)
-	return a.TrustPolicyFromOIDCIssuer(issuer, namespace, serviceAccount), nil
+	return aws.StringValue(describeClusterResult.Cluster.Identity.Oidc.Issuer), nil
 }
 
-func (a *awsWrapper) EnsureRole(roleName, policyName, trustPolicy string) error {
+// EnsureRole ensures the role with the trust policy, and attaches the
+// policies to it. Policies are given by name or ARN, see policyARNs.
+func (a *awsWrapper) EnsureRole(roleName string, policies []string, trustPolicy string, opts RoleOptions) (*EnsureResult, error) {
 	log.Printf("Ensuring role %s", roleName)
+	result := &EnsureResult{}
+	desiredTrust, err := ParsePolicyDocument([]byte(trustPolicy))
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing trust policy for role %s", roleName)
+	}
+	path, err := normalizePath(opts.Path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "role %s", roleName)
+	}
+	policyPath, err := normalizePath(opts.PolicyPath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "role %s policies", roleName)
+	}
+	policyARNs, err := a.policyARNs(policies, policyPath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "role %s", roleName)
+	}
+	inlinePolicies, err := parseInlinePolicies(opts.InlinePolicies)
+	if err != nil {
+		return nil, errors.Wrapf(err, "role %s", roleName)
+	}
+	if opts.PermissionsBoundary != "" && opts.RemovePermissionsBoundary {
+		return nil, errors.Errorf("role %s: can't both set and remove the permissions boundary", roleName)
+	}
+	boundaryARN, err := a.permissionsBoundaryARN(opts.PermissionsBoundary, policyPath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "role %s", roleName)
+	}
+	if err := validateRoleSettings(opts); err != nil {
+		return nil, errors.Wrapf(err, "role %s", roleName)
+	}
+	description, maxSessionDuration := opts.Description, opts.MaxSessionDuration
+	// The managed-by tag is only set when the role is created, see tagChanges.
+	tags := desiredTags(opts.Tags)
 	getResult, err := a.iam.GetRole(&iam.GetRoleInput{
 		RoleName: aws.String(roleName),
 	})
 	if err != nil && !isNoSuchEntityError(err) {
-		return errors.Wrapf(err, "get role %s", roleName)
+		return nil, errors.Wrapf(err, "get role %s", roleName)
 	}
-	if isNoSuchEntityError(err) {
-		_, err := a.iam.CreateRole(&iam.CreateRoleInput{
-			AssumeRolePolicyDocument: aws.String(trustPolicy),
-			RoleName:                 aws.String(roleName),
+	roleExists := !isNoSuchEntityError(err)
+	if !roleExists {
+		if !a.dryRun {
+			input := &iam.CreateRoleInput{
+				AssumeRolePolicyDocument: aws.String(trustPolicy),
+				Path:                     aws.String(path),
+				RoleName:                 aws.String(roleName),
+				Tags:                     iamTags(tags),
+			}
+			if description != "" {
+				input.Description = aws.String(description)
+			}
+			if maxSessionDuration != 0 {
+				input.MaxSessionDuration = aws.Int64(int64(maxSessionDuration / time.Second))
+			}
+			if boundaryARN != "" {
+				input.PermissionsBoundary = aws.String(boundaryARN)
+			}
+			createResult, err := a.iam.CreateRole(input)
+			if err != nil {
+				return nil, errors.Wrapf(err, "create role %s", roleName)
+			}
+			result.ARN = aws.StringValue(createResult.Role.Arn)
+			log.Printf("Created role %s", roleName)
+		}
+		result.add(Change{
+			Action:   ChangeCreate,
+			Resource: ResourceRole,
+			Name:     roleName,
+			After:    formatPolicy(desiredTrust),
 		})
-		if err != nil {
-			return errors.Wrapf(err, "create role %s", roleName)
+		if boundaryARN != "" {
+			result.add(Change{
+				Action:   ChangeCreate,
+				Resource: ResourcePermissionsBoundary,
+				Name:     roleName,
+				After:    boundaryARN,
+			})
 		}
-		log.Printf("Created role %s", roleName)
-	} else if aws.StringValue(getResult.Role.AssumeRolePolicyDocument) != trustPolicy {
-		if _, err := a.iam.UpdateAssumeRolePolicy(&iam.UpdateAssumeRolePolicyInput{
-			RoleName:       aws.String(roleName),
-			PolicyDocument: aws.String(trustPolicy),
-		}); err != nil {
-			return errors.Wrapf(err, "update role %s trust policy", roleName)
+		if description != "" {
+			result.add(Change{
+				Action:   ChangeCreate,
+				Resource: ResourceRoleDescription,
+				Name:     roleName,
+				After:    description,
+			})
 		}
-		log.Printf("Updated role %s trust policy", roleName)
-	}
-	listAttachedPoliciesResult, err := a.iam.ListAttachedRolePolicies(&iam.ListAttachedRolePoliciesInput{
-		RoleName: aws.String(roleName),
-	})
-	if err != nil {
-		return errors.Wrapf(err, "list role %s attached policies", roleName)
-	}
-	found := false
-	policyARN := a.arn("policy", policyName)
-	for _, policy := range listAttachedPoliciesResult.AttachedPolicies {
-		getPolicyResult, err := a.iam.GetPolicy(&iam.GetPolicyInput{
-			PolicyArn: policy.PolicyArn,
-		})
+		if maxSessionDuration != 0 && maxSessionDuration != defaultMaxSessionDuration {
+			result.add(Change{
+				Action:   ChangeCreate,
+				Resource: ResourceMaxSessionDuration,
+				Name:     roleName,
+				After:    maxSessionDuration.String(),
+			})
+		}
+	} else {
+		result.ARN = aws.StringValue(getResult.Role.Arn)
+		// Roles can only be looked up by name, and their path can't be
+		// changed.
+		if currentPath := aws.StringValue(getResult.Role.Path); currentPath != path {
+			return nil, errors.Errorf("role %s exists with path %s instead of %s, paths can't be changed", roleName, currentPath, path)
+		}
+		currentTrust, err := decodePolicyDocument(aws.StringValue(getResult.Role.AssumeRolePolicyDocument))
 		if err != nil {
-			return errors.Wrapf(err, "get policy %s for role %s", aws.StringValue(policy.PolicyArn), roleName)
+			return nil, errors.Wrapf(err, "parsing role %s trust policy", roleName)
 		}
-		if aws.StringValue(getPolicyResult.Policy.Arn) == aws.StringValue(policyARN) {
-			found = true
-			break
+		if PoliciesEquivalent(currentTrust, desiredTrust) {
+			log.Printf("Existing trust policy for role %s matches requested trust policy", roleName)
+		} else {
+			log.Printf("Existing trust policy for role %s does not match requested trust policy:\n%s", roleName, policyDiff(currentTrust, desiredTrust))
+			if !a.dryRun {
+				if _, err := a.iam.UpdateAssumeRolePolicy(&iam.UpdateAssumeRolePolicyInput{
+					RoleName:       aws.String(roleName),
+					PolicyDocument: aws.String(trustPolicy),
+				}); err != nil {
+					return nil, errors.Wrapf(err, "update role %s trust policy", roleName)
+				}
+				log.Printf("Updated role %s trust policy", roleName)
+			}
+			result.add(Change{
+				Action:   ChangeUpdate,
+				Resource: ResourceTrustPolicy,
+				Name:     roleName,
+				Before:   formatPolicy(currentTrust),
+				After:    formatPolicy(desiredTrust),
+			})
 		}
-		log.Printf("Found attached policy %s for role %s", policyName, roleName)
-	}
-	if !found {
-		_, err := a.iam.AttachRolePolicy(&iam.AttachRolePolicyInput{
-			PolicyArn: policyARN,
-			RoleName:  aws.String(roleName),
-		})
+		err = a.reconcileTags(ResourceRoleTags, roleName, getResult.Role.Tags, tags,
+			func(t []*iam.Tag) error {
+				_, err := a.iam.TagRole(&iam.TagRoleInput{RoleName: aws.String(roleName), Tags: t})
+				return err
+			},
+			func(keys []*string) error {
+				_, err := a.iam.UntagRole(&iam.UntagRoleInput{RoleName: aws.String(roleName), TagKeys: keys})
+				return err
+			},
+			result)
 		if err != nil {
-			return errors.Wrapf(err, "attach policy %s to role %s", policyName, roleName)
+			return nil, err
+		}
+		// Removing a boundary by accident would lift a security control, so
+		// it is only removed on request.
+		if boundaryARN != "" || opts.RemovePermissionsBoundary {
+			if err := a.ensurePermissionsBoundary(roleName, getResult.Role.PermissionsBoundary, boundaryARN, result); err != nil {
+				return nil, err
+			}
+		}
+		if err := a.ensureRoleSettings(roleName, getResult.Role, description, maxSessionDuration, result); err != nil {
+			return nil, err
 		}
-		log.Printf("Attached policy %s to role %s", policyName, roleName)
 	}
-	return nil
+	if err := a.ensureAttachedPolicies(roleName, policyARNs, roleExists, opts.ExclusivePolicies, result); err != nil {
+		return nil, err
+	}
+	if err := a.ensureInlinePolicies(roleName, inlinePolicies, roleExists, opts.ExclusiveInlinePolicies, result); err != nil {
+		return nil, err
+	}
+	return result, nil
 }
 
-func (a *awsWrapper) EnsurePolicy(policyName string, policyDocument []byte) error {
+func (a *awsWrapper) EnsurePolicy(policyName string, policyDocument []byte, opts PolicyOptions) (*EnsureResult, error) {
 	log.Printf("Ensuring policy %s", policyName)
-	document, err := cleanPolicy(policyDocument)
+	result := &EnsureResult{}
+	desired, err := ParsePolicyDocument(policyDocument)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing policy document")
+	}
+	buf, err := json.Marshal(desired)
 	if err != nil {
-		return errors.Wrapf(err, "(de)serializing policy document")
+		return nil, errors.Wrapf(err, "serializing policy document")
 	}
-	policyARN := a.arn("policy", policyName)
+	document := string(buf)
+	tags := desiredTags(opts.Tags)
+	path, err := normalizePath(opts.Path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "policy %s", policyName)
+	}
+	policyARN := a.arn("policy", path, policyName)
 	getResult, err := a.iam.GetPolicy(&iam.GetPolicyInput{
 		PolicyArn: policyARN,
 	})
 	if err != nil && !isNoSuchEntityError(err) {
-		return err
+		return nil, err
 	}
 	if isNoSuchEntityError(err) {
-		_, err := a.iam.CreatePolicy(&iam.CreatePolicyInput{
-			PolicyDocument: aws.String(document),
-			PolicyName:     aws.String(policyName),
+		result.ARN = aws.StringValue(policyARN)
+		if !a.dryRun {
+			createResult, err := a.iam.CreatePolicy(&iam.CreatePolicyInput{
+				Path:           aws.String(path),
+				PolicyDocument: aws.String(document),
+				PolicyName:     aws.String(policyName),
+				Tags:           iamTags(tags),
+			})
+			if isEntityAlreadyExistsError(err) {
+				// Policies are looked up by ARN, which includes the path.
+				return nil, errors.Errorf("policy %s exists with another path than %s, paths can't be changed", policyName, path)
+			}
+			if err != nil {
+				return nil, err
+			}
+			result.ARN = aws.StringValue(createResult.Policy.Arn)
+			result.VersionID = aws.StringValue(createResult.Policy.DefaultVersionId)
+			result.VersionCreated = true
+			log.Printf("Created policy %s", policyName)
+		}
+		result.add(Change{
+			Action:   ChangeCreate,
+			Resource: ResourcePolicy,
+			Name:     policyName,
+			After:    formatPolicy(desired),
 		})
-		if err != nil {
+		return result, nil
+	}
+	result.ARN = aws.StringValue(getResult.Policy.Arn)
+	result.VersionID = aws.StringValue(getResult.Policy.DefaultVersionId)
+	err = a.reconcileTags(ResourcePolicyTags, policyName, getResult.Policy.Tags, tags,
+		func(t []*iam.Tag) error {
+			_, err := a.iam.TagPolicy(&iam.TagPolicyInput{PolicyArn: policyARN, Tags: t})
 			return err
-		}
-		log.Printf("Created policy %s", policyName)
-		return nil
+		},
+		func(keys []*string) error {
+			_, err := a.iam.UntagPolicy(&iam.UntagPolicyInput{PolicyArn: policyARN, TagKeys: keys})
+			return err
+		},
+		result)
+	if err != nil {
+		return nil, err
 	}
 	getVersionResult, err := a.iam.GetPolicyVersion(&iam.GetPolicyVersionInput{
 		PolicyArn: policyARN,
 		VersionId: getResult.Policy.DefaultVersionId,
 	})
 	if err != nil {
-		return errors.Wrapf(err, "get policy version")
+		return nil, errors.Wrapf(err, "get policy version")
 	}
-	currentPolicyDocument, err := url.QueryUnescape(aws.StringValue(getVersionResult.PolicyVersion.Document))
+	current, err := decodePolicyDocument(aws.StringValue(getVersionResult.PolicyVersion.Document))
 	if err != nil {
-		return errors.Wrapf(err, "decoding policy document from GetPolicyVersion")
+		return nil, errors.Wrapf(err, "parsing policy document from GetPolicyVersion")
 	}
-	currentDocument, err := cleanPolicy([]byte(currentPolicyDocument))
-	if err != nil {
-		return errors.Wrapf(err, "(de)serializing policy document")
-	}
-	if currentDocument == document {
+	if PoliciesEquivalent(current, desired) {
 		log.Printf("Existing policy document for %s matches requested policy", policyName)
-		return nil
+		return result, nil
 	}
-	log.Printf("Existing policy document for %s does not match requested policy", policyName)
+	log.Printf("Existing policy document for %s does not match requested policy:\n%s", policyName, policyDiff(current, desired))
 	listVersionsResult, err := a.iam.ListPolicyVersions(&iam.ListPolicyVersionsInput{
 		PolicyArn: policyARN,
 	})
 	if err != nil {
-		return err
+		return nil, err
 	}
 	var version *iam.PolicyVersion
 	for i := range listVersionsResult.Versions {
@@ -263,23 +496,42 @@ func (a *awsWrapper) EnsurePolicy(policyName string, policyDocument []byte) erro
 	if version != nil {
 		// There are at least one non-default version. Delete it first to make
 		// sure the limit on the number of versions is not reached.
-		_, err := a.iam.DeletePolicyVersion(&iam.DeletePolicyVersionInput{
-			PolicyArn: policyARN,
-			VersionId: version.VersionId,
+		if !a.dryRun {
+			_, err := a.iam.DeletePolicyVersion(&iam.DeletePolicyVersionInput{
+				PolicyArn: policyARN,
+				VersionId: version.VersionId,
+			})
+			if err != nil {
+				return nil, err
+			}
+			log.Printf("Deleted policy version %s", aws.StringValue(version.VersionId))
+		}
+		result.add(Change{
+			Action:   ChangeDelete,
+			Resource: ResourcePolicyVersion,
+			Name:     policyName,
+			Before:   aws.StringValue(version.VersionId),
+		})
+	}
+	if !a.dryRun {
+		createVersionResult, err := a.iam.CreatePolicyVersion(&iam.CreatePolicyVersionInput{
+			PolicyArn:      policyARN,
+			PolicyDocument: aws.String(document),
+			SetAsDefault:   aws.Bool(true),
 		})
 		if err != nil {
-			return err
+			return nil, err
 		}
-		log.Printf("Deleted policy version %s", aws.StringValue(version.VersionId))
+		result.VersionID = aws.StringValue(createVersionResult.PolicyVersion.VersionId)
+		result.VersionCreated = true
+		log.Printf("Created policy version %s", result.VersionID)
 	}
-	createVersionResult, err := a.iam.CreatePolicyVersion(&iam.CreatePolicyVersionInput{
-		PolicyArn:      policyARN,
-		PolicyDocument: aws.String(string(document)),
-		SetAsDefault:   aws.Bool(true),
+	result.add(Change{
+		Action:   ChangeUpdate,
+		Resource: ResourcePolicy,
+		Name:     policyName,
+		Before:   formatPolicy(current),
+		After:    formatPolicy(desired),
 	})
-	if err != nil {
-		return err
-	}
-	log.Printf("Created policy version %s", aws.StringValue(createVersionResult.PolicyVersion.VersionId))
-	return nil
+	return result, nil
 }
@@ -3,7 +3,9 @@ package awswrapper
 import (
 	"encoding/json"
 	"fmt"
+	"net/url"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
@@ -18,82 +20,195 @@ type mockedEKSAPI struct {
 	eksiface.EKSAPI
 	resp *eks.DescribeClusterOutput
 	err  error
+	// issuers, if set, maps cluster names to OIDC issuers.
+	issuers map[string]string
 }
 
 func (m mockedEKSAPI) DescribeCluster(in *eks.DescribeClusterInput) (*eks.DescribeClusterOutput, error) {
+	if m.issuers != nil {
+		issuer, ok := m.issuers[aws.StringValue(in.Name)]
+		if !ok {
+			return nil, awserr.New(eks.ErrCodeResourceNotFoundException, "", nil)
+		}
+		return &eks.DescribeClusterOutput{
+			Cluster: &eks.Cluster{
+				Identity: &eks.Identity{
+					Oidc: &eks.OIDC{
+						Issuer: aws.String(issuer),
+					},
+				},
+			},
+		}, nil
+	}
 	return m.resp, m.err
 }
 
 type mockedIAMAPI struct {
 	iamiface.IAMAPI
-	attachRolePolicyErr         error
-	attachRolePolicyOut         *iam.AttachRolePolicyOutput
-	createPolicyErr             error
-	createPolicyOut             *iam.CreatePolicyOutput
-	createPolicyVersionErr      error
-	createPolicyVersionOut      *iam.CreatePolicyVersionOutput
-	createRoleErr               error
-	createRoleOut               *iam.CreateRoleOutput
-	deletePolicyVersionErr      error
-	deletePolicyVersionOut      *iam.DeletePolicyVersionOutput
-	getPolicyErr                error
-	getPolicyOut                *iam.GetPolicyOutput
-	getPolicyVersionErr         error
-	getPolicyVersionOut         *iam.GetPolicyVersionOutput
-	getRoleErr                  error
-	getRoleOut                  *iam.GetRoleOutput
-	listAttachedRolePoliciesErr error
-	listAttachedRolePoliciesOut *iam.ListAttachedRolePoliciesOutput
-	listPolicyVersionsErr       error
-	listPolicyVersionsOut       *iam.ListPolicyVersionsOutput
-}
-
-func (m mockedIAMAPI) AttachRolePolicy(in *iam.AttachRolePolicyInput) (*iam.AttachRolePolicyOutput, error) {
+	attachRolePolicyErr              error
+	attachRolePolicyOut              *iam.AttachRolePolicyOutput
+	createPolicyErr                  error
+	createPolicyIn                   *iam.CreatePolicyInput
+	createPolicyOut                  *iam.CreatePolicyOutput
+	createPolicyVersionErr           error
+	createPolicyVersionOut           *iam.CreatePolicyVersionOutput
+	createRoleErr                    error
+	createRoleIn                     *iam.CreateRoleInput
+	createRoleOut                    *iam.CreateRoleOutput
+	deletePolicyErr                  error
+	deletePolicyOut                  *iam.DeletePolicyOutput
+	deletePolicyVersionErr           error
+	deletePolicyVersionOut           *iam.DeletePolicyVersionOutput
+	deleteRoleErr                    error
+	deleteRoleOut                    *iam.DeleteRoleOutput
+	deleteRolePermissionsBoundaryErr error
+	deleteRolePermissionsBoundaryOut *iam.DeleteRolePermissionsBoundaryOutput
+	deleteRolePolicyErr              error
+	deleteRolePolicyOut              *iam.DeleteRolePolicyOutput
+	detachRolePolicyErr              error
+	detachRolePolicyOut              *iam.DetachRolePolicyOutput
+	getPolicyErr                     error
+	getPolicyOut                     *iam.GetPolicyOutput
+	getPolicyVersionErr              error
+	getPolicyVersionOut              *iam.GetPolicyVersionOutput
+	getRoleErr                       error
+	getRoleOut                       *iam.GetRoleOutput
+	getRolePolicyErr                 error
+	getRolePolicyOut                 *iam.GetRolePolicyOutput
+	listAttachedRolePoliciesErr      error
+	listAttachedRolePoliciesOut      *iam.ListAttachedRolePoliciesOutput
+	listPolicyVersionsErr            error
+	listPolicyVersionsOut            *iam.ListPolicyVersionsOutput
+	listRolePoliciesErr              error
+	listRolePoliciesOut              *iam.ListRolePoliciesOutput
+	putRolePermissionsBoundaryErr    error
+	putRolePermissionsBoundaryOut    *iam.PutRolePermissionsBoundaryOutput
+	putRolePolicyErr                 error
+	putRolePolicyOut                 *iam.PutRolePolicyOutput
+	tagPolicyErr                     error
+	tagPolicyOut                     *iam.TagPolicyOutput
+	tagRoleErr                       error
+	tagRoleOut                       *iam.TagRoleOutput
+	untagPolicyErr                   error
+	untagPolicyOut                   *iam.UntagPolicyOutput
+	untagRoleErr                     error
+	untagRoleOut                     *iam.UntagRoleOutput
+	updateAssumeRolePolicyErr        error
+	updateAssumeRolePolicyOut        *iam.UpdateAssumeRolePolicyOutput
+	updateRoleErr                    error
+	updateRoleOut                    *iam.UpdateRoleOutput
+}
+
+func (m *mockedIAMAPI) AttachRolePolicy(in *iam.AttachRolePolicyInput) (*iam.AttachRolePolicyOutput, error) {
 	return m.attachRolePolicyOut, m.attachRolePolicyErr
 }
 
-func (m mockedIAMAPI) CreatePolicy(in *iam.CreatePolicyInput) (*iam.CreatePolicyOutput, error) {
+func (m *mockedIAMAPI) CreatePolicy(in *iam.CreatePolicyInput) (*iam.CreatePolicyOutput, error) {
+	m.createPolicyIn = in
 	return m.createPolicyOut, m.createPolicyErr
 }
 
-func (m mockedIAMAPI) CreateRole(in *iam.CreateRoleInput) (*iam.CreateRoleOutput, error) {
+func (m *mockedIAMAPI) CreateRole(in *iam.CreateRoleInput) (*iam.CreateRoleOutput, error) {
+	m.createRoleIn = in
 	return m.createRoleOut, m.createRoleErr
 }
 
-func (m mockedIAMAPI) CreatePolicyVersion(in *iam.CreatePolicyVersionInput) (*iam.CreatePolicyVersionOutput, error) {
+func (m *mockedIAMAPI) CreatePolicyVersion(in *iam.CreatePolicyVersionInput) (*iam.CreatePolicyVersionOutput, error) {
 	return m.createPolicyVersionOut, m.createPolicyVersionErr
 }
 
-func (m mockedIAMAPI) DeletePolicyVersion(in *iam.DeletePolicyVersionInput) (*iam.DeletePolicyVersionOutput, error) {
+func (m *mockedIAMAPI) DeletePolicy(in *iam.DeletePolicyInput) (*iam.DeletePolicyOutput, error) {
+	return m.deletePolicyOut, m.deletePolicyErr
+}
+
+func (m *mockedIAMAPI) DeleteRole(in *iam.DeleteRoleInput) (*iam.DeleteRoleOutput, error) {
+	return m.deleteRoleOut, m.deleteRoleErr
+}
+
+func (m *mockedIAMAPI) DeleteRolePermissionsBoundary(in *iam.DeleteRolePermissionsBoundaryInput) (*iam.DeleteRolePermissionsBoundaryOutput, error) {
+	return m.deleteRolePermissionsBoundaryOut, m.deleteRolePermissionsBoundaryErr
+}
+
+func (m *mockedIAMAPI) DeleteRolePolicy(in *iam.DeleteRolePolicyInput) (*iam.DeleteRolePolicyOutput, error) {
+	return m.deleteRolePolicyOut, m.deleteRolePolicyErr
+}
+
+func (m *mockedIAMAPI) DetachRolePolicy(in *iam.DetachRolePolicyInput) (*iam.DetachRolePolicyOutput, error) {
+	return m.detachRolePolicyOut, m.detachRolePolicyErr
+}
+
+func (m *mockedIAMAPI) DeletePolicyVersion(in *iam.DeletePolicyVersionInput) (*iam.DeletePolicyVersionOutput, error) {
 	return m.deletePolicyVersionOut, m.deletePolicyVersionErr
 }
 
-func (m mockedIAMAPI) GetPolicy(in *iam.GetPolicyInput) (*iam.GetPolicyOutput, error) {
+func (m *mockedIAMAPI) GetPolicy(in *iam.GetPolicyInput) (*iam.GetPolicyOutput, error) {
 	return m.getPolicyOut, m.getPolicyErr
 }
 
-func (m mockedIAMAPI) GetRole(in *iam.GetRoleInput) (*iam.GetRoleOutput, error) {
+func (m *mockedIAMAPI) GetRole(in *iam.GetRoleInput) (*iam.GetRoleOutput, error) {
 	return m.getRoleOut, m.getRoleErr
 }
 
-func (m mockedIAMAPI) GetPolicyVersion(in *iam.GetPolicyVersionInput) (*iam.GetPolicyVersionOutput, error) {
+func (m *mockedIAMAPI) GetRolePolicy(in *iam.GetRolePolicyInput) (*iam.GetRolePolicyOutput, error) {
+	return m.getRolePolicyOut, m.getRolePolicyErr
+}
+
+func (m *mockedIAMAPI) GetPolicyVersion(in *iam.GetPolicyVersionInput) (*iam.GetPolicyVersionOutput, error) {
 	return m.getPolicyVersionOut, m.getPolicyVersionErr
 }
 
-func (m mockedIAMAPI) ListAttachedRolePolicies(in *iam.ListAttachedRolePoliciesInput) (*iam.ListAttachedRolePoliciesOutput, error) {
+func (m *mockedIAMAPI) ListAttachedRolePolicies(in *iam.ListAttachedRolePoliciesInput) (*iam.ListAttachedRolePoliciesOutput, error) {
 	return m.listAttachedRolePoliciesOut, m.listAttachedRolePoliciesErr
 }
 
-func (m mockedIAMAPI) ListPolicyVersions(in *iam.ListPolicyVersionsInput) (*iam.ListPolicyVersionsOutput, error) {
+func (m *mockedIAMAPI) ListPolicyVersions(in *iam.ListPolicyVersionsInput) (*iam.ListPolicyVersionsOutput, error) {
 	return m.listPolicyVersionsOut, m.listPolicyVersionsErr
 }
 
+func (m *mockedIAMAPI) ListRolePolicies(in *iam.ListRolePoliciesInput) (*iam.ListRolePoliciesOutput, error) {
+	return m.listRolePoliciesOut, m.listRolePoliciesErr
+}
+
+func (m *mockedIAMAPI) PutRolePermissionsBoundary(in *iam.PutRolePermissionsBoundaryInput) (*iam.PutRolePermissionsBoundaryOutput, error) {
+	return m.putRolePermissionsBoundaryOut, m.putRolePermissionsBoundaryErr
+}
+
+func (m *mockedIAMAPI) PutRolePolicy(in *iam.PutRolePolicyInput) (*iam.PutRolePolicyOutput, error) {
+	return m.putRolePolicyOut, m.putRolePolicyErr
+}
+
+func (m *mockedIAMAPI) TagPolicy(in *iam.TagPolicyInput) (*iam.TagPolicyOutput, error) {
+	return m.tagPolicyOut, m.tagPolicyErr
+}
+
+func (m *mockedIAMAPI) TagRole(in *iam.TagRoleInput) (*iam.TagRoleOutput, error) {
+	return m.tagRoleOut, m.tagRoleErr
+}
+
+func (m *mockedIAMAPI) UntagPolicy(in *iam.UntagPolicyInput) (*iam.UntagPolicyOutput, error) {
+	return m.untagPolicyOut, m.untagPolicyErr
+}
+
+func (m *mockedIAMAPI) UntagRole(in *iam.UntagRoleInput) (*iam.UntagRoleOutput, error) {
+	return m.untagRoleOut, m.untagRoleErr
+}
+
+func (m *mockedIAMAPI) UpdateAssumeRolePolicy(in *iam.UpdateAssumeRolePolicyInput) (*iam.UpdateAssumeRolePolicyOutput, error) {
+	return m.updateAssumeRolePolicyOut, m.updateAssumeRolePolicyErr
+}
+
+func (m *mockedIAMAPI) UpdateRole(in *iam.UpdateRoleInput) (*iam.UpdateRoleOutput, error) {
+	return m.updateRoleOut, m.updateRoleErr
+}
+
 func TestEnsurePolicy(t *testing.T) {
 	testCases := []struct {
-		mock *mockedIAMAPI
-		err  bool
-		name string
-		doc  string
+		mock     *mockedIAMAPI
+		err      bool
+		name     string
+		doc      string
+		opts     PolicyOptions
+		createIn *iam.CreatePolicyInput
 	}{
 		// Invalid document.
 		{
@@ -115,6 +230,16 @@ func TestEnsurePolicy(t *testing.T) {
 			err:  false,
 			name: "my-policy-1",
 			doc:  "{}",
+			opts: PolicyOptions{Tags: map[string]string{"team": "web"}},
+			createIn: &iam.CreatePolicyInput{
+				Path:           aws.String("/"),
+				PolicyDocument: aws.String("{}"),
+				PolicyName:     aws.String("my-policy-1"),
+				Tags: []*iam.Tag{
+					{Key: aws.String(ManagedByTagKey), Value: aws.String(ManagedByTagValue)},
+					{Key: aws.String("team"), Value: aws.String("web")},
+				},
+			},
 		},
 		{
 			mock: &mockedIAMAPI{
@@ -151,6 +276,26 @@ func TestEnsurePolicy(t *testing.T) {
 			name: "my-policy-2",
 			doc:  "{}",
 		},
+		// Policy default version already exists and document is equivalent.
+		{
+			mock: &mockedIAMAPI{
+				createPolicyVersionErr: fmt.Errorf("CreatePolicyVersion should not be called"),
+				getPolicyOut: &iam.GetPolicyOutput{
+					Policy: &iam.Policy{
+						DefaultVersionId: aws.String("my-existing-version"),
+					},
+				},
+				getPolicyVersionOut: &iam.GetPolicyVersionOutput{
+					PolicyVersion: &iam.PolicyVersion{
+						Document: aws.String(url.QueryEscape(`{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Action":["s3:ListBucket","s3:GetBucketLocation"],"Resource":"*"}]}`)),
+					},
+				},
+				listPolicyVersionsErr: fmt.Errorf("ListPolicyVersions should not be called"),
+			},
+			err:  false,
+			name: "my-policy-2-equivalent",
+			doc:  `{"Statement":{"Effect":"Allow","Action":["s3:getbucketlocation","s3:ListBucket"],"Resource":["*"]}}`,
+		},
 		{
 			mock: &mockedIAMAPI{
 				getPolicyOut: &iam.GetPolicyOutput{
@@ -331,27 +476,306 @@ func TestEnsurePolicy(t *testing.T) {
 	}
 	for _, tc := range testCases {
 		aw := awsWrapper{iam: tc.mock}
-		err := aw.EnsurePolicy(tc.name, []byte(tc.doc))
+		_, err := aw.EnsurePolicy(tc.name, []byte(tc.doc), tc.opts)
 		if tc.err {
 			assert.Error(t, err)
 		} else {
 			assert.NoError(t, err)
 		}
+		if tc.createIn != nil {
+			assert.Equal(t, tc.createIn, tc.mock.createPolicyIn, tc.name)
+		}
 	}
 }
 
 func TestEnsureRole(t *testing.T) {
-	// TODO: tests for EnsureRole(roleName string, policyName, trustPolicy string) error
+	trustPolicy := `{
+  "Version": "2012-10-17",
+  "Statement": [
+    {
+      "Effect": "Allow",
+      "Principal": {
+        "Federated": "arn:aws:iam::123456789012:oidc-provider/my-issuer"
+      },
+      "Action": "sts:AssumeRoleWithWebIdentity",
+      "Condition": {
+        "StringEquals": {
+          "my-issuer:sub": "system:serviceaccount:my-namespace:my-service-account"
+        }
+      }
+    }
+  ]
+}`
+	attachedPolicies := &iam.ListAttachedRolePoliciesOutput{
+		AttachedPolicies: []*iam.AttachedPolicy{
+			{
+				PolicyArn:  aws.String("arn:aws:iam::123456789012:policy/my-policy"),
+				PolicyName: aws.String("my-policy"),
+			},
+		},
+	}
+	attachedPolicy := &iam.GetPolicyOutput{
+		Policy: &iam.Policy{
+			Arn: aws.String("arn:aws:iam::123456789012:policy/my-policy"),
+		},
+	}
+	testCases := []struct {
+		mock        *mockedIAMAPI
+		err         bool
+		name        string
+		trustPolicy string
+		opts        RoleOptions
+		createIn    *iam.CreateRoleInput
+	}{
+		// Invalid trust policy.
+		{
+			mock:        &mockedIAMAPI{},
+			err:         true,
+			name:        "my-role-0",
+			trustPolicy: "invalid trust policy",
+		},
+		// Role does not exist.
+		{
+			mock: &mockedIAMAPI{
+				attachRolePolicyOut: &iam.AttachRolePolicyOutput{},
+				createRoleOut: &iam.CreateRoleOutput{
+					Role: &iam.Role{
+						Path: aws.String("/"),
+						Arn:  aws.String("arn:aws:iam::123456789012:role/my-role-1"),
+					},
+				},
+				getRoleErr:                  awserr.New(iam.ErrCodeNoSuchEntityException, "", nil),
+				listAttachedRolePoliciesOut: &iam.ListAttachedRolePoliciesOutput{},
+			},
+			err:         false,
+			name:        "my-role-1",
+			trustPolicy: trustPolicy,
+			opts:        RoleOptions{Tags: map[string]string{"team": "web"}},
+			createIn: &iam.CreateRoleInput{
+				AssumeRolePolicyDocument: aws.String(trustPolicy),
+				Path:                     aws.String("/"),
+				RoleName:                 aws.String("my-role-1"),
+				Tags: []*iam.Tag{
+					{Key: aws.String(ManagedByTagKey), Value: aws.String(ManagedByTagValue)},
+					{Key: aws.String("team"), Value: aws.String("web")},
+				},
+			},
+		},
+		// Role does not exist, and is created with a permissions boundary.
+		{
+			mock: &mockedIAMAPI{
+				attachRolePolicyOut: &iam.AttachRolePolicyOutput{},
+				createRoleOut: &iam.CreateRoleOutput{
+					Role: &iam.Role{
+						Path: aws.String("/"),
+						Arn:  aws.String("arn:aws:iam::123456789012:role/my-role-1-boundary"),
+					},
+				},
+				getPolicyOut:                &iam.GetPolicyOutput{},
+				getRoleErr:                  awserr.New(iam.ErrCodeNoSuchEntityException, "", nil),
+				listAttachedRolePoliciesOut: &iam.ListAttachedRolePoliciesOutput{},
+			},
+			err:         false,
+			name:        "my-role-1-boundary",
+			trustPolicy: trustPolicy,
+			opts:        RoleOptions{PermissionsBoundary: "my-boundary"},
+			createIn: &iam.CreateRoleInput{
+				AssumeRolePolicyDocument: aws.String(trustPolicy),
+				Path:                     aws.String("/"),
+				PermissionsBoundary:      aws.String("arn:aws:iam::123456789012:policy/my-boundary"),
+				RoleName:                 aws.String("my-role-1-boundary"),
+				Tags: []*iam.Tag{
+					{Key: aws.String(ManagedByTagKey), Value: aws.String(ManagedByTagValue)},
+				},
+			},
+		},
+		// Role does not exist, and is created with a description and a
+		// maximum session duration.
+		{
+			mock: &mockedIAMAPI{
+				attachRolePolicyOut: &iam.AttachRolePolicyOutput{},
+				createRoleOut: &iam.CreateRoleOutput{
+					Role: &iam.Role{
+						Path: aws.String("/"),
+						Arn:  aws.String("arn:aws:iam::123456789012:role/my-role-1-settings"),
+					},
+				},
+				getRoleErr:                  awserr.New(iam.ErrCodeNoSuchEntityException, "", nil),
+				listAttachedRolePoliciesOut: &iam.ListAttachedRolePoliciesOutput{},
+			},
+			err:         false,
+			name:        "my-role-1-settings",
+			trustPolicy: trustPolicy,
+			opts:        RoleOptions{Description: "Role of my-app", MaxSessionDuration: 4 * time.Hour},
+			createIn: &iam.CreateRoleInput{
+				AssumeRolePolicyDocument: aws.String(trustPolicy),
+				Description:              aws.String("Role of my-app"),
+				MaxSessionDuration:       aws.Int64(14400),
+				Path:                     aws.String("/"),
+				RoleName:                 aws.String("my-role-1-settings"),
+				Tags: []*iam.Tag{
+					{Key: aws.String(ManagedByTagKey), Value: aws.String(ManagedByTagValue)},
+				},
+			},
+		},
+		{
+			mock: &mockedIAMAPI{
+				createRoleErr: fmt.Errorf("CreateRole test error"),
+				getRoleErr:    awserr.New(iam.ErrCodeNoSuchEntityException, "", nil),
+			},
+			err:         true,
+			name:        "my-role-1-err-0",
+			trustPolicy: trustPolicy,
+		},
+		{
+			mock: &mockedIAMAPI{
+				getRoleErr: fmt.Errorf("GetRole test error"),
+			},
+			err:         true,
+			name:        "my-role-1-err-1",
+			trustPolicy: trustPolicy,
+		},
+		// Role exists with an equivalent, URL encoded and reformatted trust
+		// policy, and the policy is attached.
+		{
+			mock: &mockedIAMAPI{
+				getPolicyOut: attachedPolicy,
+				getRoleOut: &iam.GetRoleOutput{
+					Role: &iam.Role{
+						Path:                     aws.String("/"),
+						AssumeRolePolicyDocument: aws.String(url.QueryEscape(`{"Statement":[{"Action":"sts:AssumeRoleWithWebIdentity","Condition":{"StringEquals":{"my-issuer:sub":["system:serviceaccount:my-namespace:my-service-account"]}},"Effect":"Allow","Principal":{"Federated":"arn:aws:iam::123456789012:oidc-provider/my-issuer"}}],"Version":"2012-10-17"}`)),
+					},
+				},
+				listAttachedRolePoliciesOut: attachedPolicies,
+				updateAssumeRolePolicyErr:   fmt.Errorf("UpdateAssumeRolePolicy should not be called"),
+			},
+			err:         false,
+			name:        "my-role-2",
+			trustPolicy: trustPolicy,
+		},
+		// Role exists with a different trust policy.
+		{
+			mock: &mockedIAMAPI{
+				getPolicyOut: attachedPolicy,
+				getRoleOut: &iam.GetRoleOutput{
+					Role: &iam.Role{
+						Path:                     aws.String("/"),
+						AssumeRolePolicyDocument: aws.String(url.QueryEscape(`{"Version":"2012-10-17","Statement":[]}`)),
+					},
+				},
+				listAttachedRolePoliciesOut: attachedPolicies,
+				updateAssumeRolePolicyOut:   &iam.UpdateAssumeRolePolicyOutput{},
+			},
+			err:         false,
+			name:        "my-role-3",
+			trustPolicy: trustPolicy,
+		},
+		{
+			mock: &mockedIAMAPI{
+				getRoleOut: &iam.GetRoleOutput{
+					Role: &iam.Role{
+						Path:                     aws.String("/"),
+						AssumeRolePolicyDocument: aws.String(url.QueryEscape(`{"Version":"2012-10-17","Statement":[]}`)),
+					},
+				},
+				updateAssumeRolePolicyErr: fmt.Errorf("UpdateAssumeRolePolicy test error"),
+			},
+			err:         true,
+			name:        "my-role-3-err-0",
+			trustPolicy: trustPolicy,
+		},
+		{
+			mock: &mockedIAMAPI{
+				getRoleOut: &iam.GetRoleOutput{
+					Role: &iam.Role{
+						Path:                     aws.String("/"),
+						AssumeRolePolicyDocument: aws.String("%7Binvalid"),
+					},
+				},
+			},
+			err:         true,
+			name:        "my-role-3-err-1",
+			trustPolicy: trustPolicy,
+		},
+		// Policy is not attached yet.
+		{
+			mock: &mockedIAMAPI{
+				attachRolePolicyOut: &iam.AttachRolePolicyOutput{},
+				getRoleOut: &iam.GetRoleOutput{
+					Role: &iam.Role{
+						Path:                     aws.String("/"),
+						AssumeRolePolicyDocument: aws.String(url.QueryEscape(trustPolicy)),
+					},
+				},
+				listAttachedRolePoliciesOut: &iam.ListAttachedRolePoliciesOutput{},
+			},
+			err:         false,
+			name:        "my-role-4",
+			trustPolicy: trustPolicy,
+		},
+		{
+			mock: &mockedIAMAPI{
+				attachRolePolicyErr: fmt.Errorf("AttachRolePolicy test error"),
+				getRoleOut: &iam.GetRoleOutput{
+					Role: &iam.Role{
+						Path:                     aws.String("/"),
+						AssumeRolePolicyDocument: aws.String(url.QueryEscape(trustPolicy)),
+					},
+				},
+				listAttachedRolePoliciesOut: &iam.ListAttachedRolePoliciesOutput{},
+			},
+			err:         true,
+			name:        "my-role-4-err-0",
+			trustPolicy: trustPolicy,
+		},
+		{
+			mock: &mockedIAMAPI{
+				getRoleOut: &iam.GetRoleOutput{
+					Role: &iam.Role{
+						Path:                     aws.String("/"),
+						AssumeRolePolicyDocument: aws.String(url.QueryEscape(trustPolicy)),
+					},
+				},
+				listAttachedRolePoliciesErr: fmt.Errorf("ListAttachedRolePolicies test error"),
+			},
+			err:         true,
+			name:        "my-role-4-err-1",
+			trustPolicy: trustPolicy,
+		},
+	}
+	for _, tc := range testCases {
+		aw := awsWrapper{accountID: "123456789012", iam: tc.mock}
+		_, err := aw.EnsureRole(tc.name, []string{"my-policy"}, tc.trustPolicy, tc.opts)
+		if tc.err {
+			assert.Error(t, err, tc.name)
+		} else {
+			assert.NoError(t, err, tc.name)
+		}
+		if tc.createIn != nil {
+			assert.Equal(t, tc.createIn, tc.mock.createRoleIn, tc.name)
+		}
+	}
 }
 
-func TestTrustPolicyFromCluster(t *testing.T) {
-	aw := awsWrapper{
+// trustPolicyFromClusters builds a trust policy like the callers of
+// awsWrapper do, from the OIDC issuers of the clusters.
+func trustPolicyFromClusters(aw *awsWrapper, clusterNames []string, opts TrustOptions) (string, error) {
+	issuers, err := aw.OIDCIssuersFromClusters(clusterNames)
+	if err != nil {
+		return "", err
+	}
+	return aw.TrustPolicyFromOIDCIssuers(issuers, opts)
+}
+
+func TestTrustPolicyFromClusters(t *testing.T) {
+	aw := &awsWrapper{
+		accountID: "123456789012",
 		eks: &mockedEKSAPI{
 			resp: &eks.DescribeClusterOutput{
 				Cluster: &eks.Cluster{
 					Identity: &eks.Identity{
 						Oidc: &eks.OIDC{
-							Issuer: aws.String("my-issuer"),
+							Issuer: aws.String("https://oidc.eks.us-east-1.amazonaws.com/id/my-issuer"),
 						},
 					},
 				},
@@ -359,10 +783,15 @@ func TestTrustPolicyFromCluster(t *testing.T) {
 			err: nil,
 		},
 	}
-	policy, err := aw.TrustPolicyFromCluster("my-cluster", "my-namespace", "my-service-account")
+	trustOpts := TrustOptions{
+		ServiceAccounts: []ServiceAccount{{Namespace: "my-namespace", Name: "my-service-account"}},
+	}
+	policy, err := trustPolicyFromClusters(aw, []string{"my-cluster"}, trustOpts)
 	assert.NoError(t, err)
 	assert.NotEmpty(t, policy)
-	assert.Contains(t, policy, "my-issuer")
+	assert.Contains(t, policy, "arn:aws:iam::123456789012:oidc-provider/oidc.eks.us-east-1.amazonaws.com/id/my-issuer")
+	assert.Contains(t, policy, "oidc.eks.us-east-1.amazonaws.com/id/my-issuer:sub")
+	assert.NotContains(t, policy, "https://")
 	assert.Contains(t, policy, "my-namespace")
 	assert.Contains(t, policy, "my-service-account")
 	j := make(map[string]interface{})
@@ -372,19 +801,186 @@ func TestTrustPolicyFromCluster(t *testing.T) {
 		resp: nil,
 		err:  fmt.Errorf("DescribeCluster test error"),
 	}
-	policy, err = aw.TrustPolicyFromCluster("my-cluster", "my-namespace", "my-service-account")
+	policy, err = trustPolicyFromClusters(aw, []string{"my-cluster"}, trustOpts)
+	assert.Error(t, err)
+	assert.Empty(t, policy)
+	aw.eks = &mockedEKSAPI{
+		resp: &eks.DescribeClusterOutput{
+			Cluster: &eks.Cluster{},
+		},
+	}
+	policy, err = trustPolicyFromClusters(aw, []string{"my-cluster"}, trustOpts)
 	assert.Error(t, err)
 	assert.Empty(t, policy)
 }
 
-func TestTrustPolicyFromOIDCIssuer(t *testing.T) {
+func TestTrustPolicyFromOIDCIssuers(t *testing.T) {
 	aw := awsWrapper{}
-	policy := aw.TrustPolicyFromOIDCIssuer("my-issuer", "my-namespace", "my-service-account")
+	trustOpts := TrustOptions{
+		ServiceAccounts: []ServiceAccount{{Namespace: "my-namespace", Name: "my-service-account"}},
+	}
+	policy, err := aw.TrustPolicyFromOIDCIssuers([]string{"https://my-issuer/"}, trustOpts)
+	assert.NoError(t, err)
 	assert.NotEmpty(t, policy)
-	assert.Contains(t, policy, "my-issuer")
+	assert.Contains(t, policy, "oidc-provider/my-issuer\"")
+	assert.Contains(t, policy, "\"my-issuer:sub\"")
 	assert.Contains(t, policy, "my-namespace")
 	assert.Contains(t, policy, "my-service-account")
-	j := make(map[string]interface{})
-	err := json.Unmarshal([]byte(policy), &j)
+	doc, err := ParsePolicyDocument([]byte(policy))
+	assert.NoError(t, err)
+	assert.Equal(t, ConditionValues{"sts.amazonaws.com"}, doc.Statement[0].Condition["StringEquals"]["my-issuer:aud"])
+	trustOpts.Audiences = []string{"my-audience", "sts.amazonaws.com"}
+	policy, err = aw.TrustPolicyFromOIDCIssuers([]string{"my-issuer"}, trustOpts)
+	assert.NoError(t, err)
+	doc, err = ParsePolicyDocument([]byte(policy))
+	assert.NoError(t, err)
+	assert.Equal(t, ConditionValues{"sts.amazonaws.com", "my-audience"}, doc.Statement[0].Condition["StringEquals"]["my-issuer:aud"])
+	policy, err = aw.TrustPolicyFromOIDCIssuers([]string{"http://my-issuer"}, trustOpts)
+	assert.Error(t, err)
+	assert.Empty(t, policy)
+	policy, err = aw.TrustPolicyFromOIDCIssuers([]string{"my-issuer"}, TrustOptions{})
+	assert.Error(t, err)
+	assert.Empty(t, policy)
+}
+
+func TestTrustPolicyFromOIDCIssuersMultipleServiceAccounts(t *testing.T) {
+	aw := awsWrapper{}
+	policy, err := aw.TrustPolicyFromOIDCIssuers([]string{"my-issuer"}, TrustOptions{
+		ServiceAccounts: []ServiceAccount{
+			{Namespace: "green", Name: "my-service-account"},
+			{Namespace: "blue", Name: "my-service-account"},
+			{Namespace: "green", Name: "my-service-account"},
+		},
+	})
+	assert.NoError(t, err)
+	doc, err := ParsePolicyDocument([]byte(policy))
+	assert.NoError(t, err)
+	assert.Len(t, doc.Statement, 1)
+	assert.Equal(t, ConditionValues{
+		"system:serviceaccount:blue:my-service-account",
+		"system:serviceaccount:green:my-service-account",
+	}, doc.Statement[0].Condition["StringEquals"]["my-issuer:sub"])
+	// The generated policy does not depend on the order of service accounts.
+	reordered, err := aw.TrustPolicyFromOIDCIssuers([]string{"my-issuer"}, TrustOptions{
+		ServiceAccounts: []ServiceAccount{
+			{Namespace: "blue", Name: "my-service-account"},
+			{Namespace: "green", Name: "my-service-account"},
+		},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, policy, reordered)
+}
+
+func TestTrustPolicyFromOIDCIssuersNoIssuers(t *testing.T) {
+	aw := awsWrapper{}
+	policy, err := aw.TrustPolicyFromOIDCIssuers(nil, TrustOptions{
+		ServiceAccounts: []ServiceAccount{{Namespace: "my-namespace", Name: "my-service-account"}},
+	})
+	assert.Error(t, err)
+	assert.Empty(t, policy)
+}
+
+func TestTrustPolicyFromMultipleClusters(t *testing.T) {
+	aw := &awsWrapper{
+		accountID: "123456789012",
+		eks: &mockedEKSAPI{
+			issuers: map[string]string{
+				"cluster-a": "https://oidc.eks.us-east-1.amazonaws.com/id/A",
+				"cluster-b": "https://oidc.eks.us-west-2.amazonaws.com/id/B/",
+				"cluster-c": "https://oidc.eks.us-east-1.amazonaws.com/id/A/",
+			},
+		},
+	}
+	trustOpts := TrustOptions{
+		ServiceAccounts: []ServiceAccount{{Namespace: "my-namespace", Name: "my-service-account"}},
+	}
+	issuers, err := aw.OIDCIssuersFromClusters([]string{"cluster-b", "cluster-a"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"https://oidc.eks.us-west-2.amazonaws.com/id/B/",
+		"https://oidc.eks.us-east-1.amazonaws.com/id/A",
+	}, issuers)
+	policy, err := trustPolicyFromClusters(aw, []string{"cluster-b", "cluster-a", "cluster-c"}, trustOpts)
+	assert.NoError(t, err)
+	doc, err := ParsePolicyDocument([]byte(policy))
+	assert.NoError(t, err)
+	// One statement per OIDC provider, cluster-a and cluster-c share one.
+	assert.Len(t, doc.Statement, 2)
+	assert.Equal(t, StringOrSlice{"arn:aws:iam::123456789012:oidc-provider/oidc.eks.us-east-1.amazonaws.com/id/A"}, doc.Statement[0].Principal.Values["Federated"])
+	assert.Contains(t, doc.Statement[0].Condition["StringEquals"], "oidc.eks.us-east-1.amazonaws.com/id/A:sub")
+	assert.Equal(t, StringOrSlice{"arn:aws:iam::123456789012:oidc-provider/oidc.eks.us-west-2.amazonaws.com/id/B"}, doc.Statement[1].Principal.Values["Federated"])
+	assert.Contains(t, doc.Statement[1].Condition["StringEquals"], "oidc.eks.us-west-2.amazonaws.com/id/B:sub")
+	policy, err = trustPolicyFromClusters(aw, []string{"cluster-a", "missing-cluster"}, trustOpts)
+	assert.Error(t, err)
+	assert.Empty(t, policy)
+}
+
+func TestEnsureResultARNs(t *testing.T) {
+	aw := awsWrapper{
+		accountID: "123456789012",
+		iam: &mockedIAMAPI{
+			createPolicyOut: &iam.CreatePolicyOutput{
+				Policy: &iam.Policy{
+					Arn:              aws.String("arn:aws:iam::123456789012:policy/my-policy"),
+					DefaultVersionId: aws.String("v1"),
+				},
+			},
+			getPolicyErr: awserr.New(iam.ErrCodeNoSuchEntityException, "", nil),
+		},
+	}
+	result, err := aw.EnsurePolicy("my-policy", []byte("{}"), PolicyOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, "arn:aws:iam::123456789012:policy/my-policy", result.ARN)
+	assert.Equal(t, "v1", result.VersionID)
+	assert.True(t, result.VersionCreated)
+	assert.True(t, result.Changed())
+
+	aw.iam = &mockedIAMAPI{
+		getPolicyOut: &iam.GetPolicyOutput{
+			Policy: &iam.Policy{
+				Arn:              aws.String("arn:aws:iam::123456789012:policy/my-policy"),
+				DefaultVersionId: aws.String("v3"),
+				Tags:             iamTags(desiredTags(nil)),
+			},
+		},
+		getPolicyVersionOut: &iam.GetPolicyVersionOutput{
+			PolicyVersion: &iam.PolicyVersion{
+				Document: aws.String("%7B%7D"),
+			},
+		},
+	}
+	result, err = aw.EnsurePolicy("my-policy", []byte("{}"), PolicyOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, "arn:aws:iam::123456789012:policy/my-policy", result.ARN)
+	assert.Equal(t, "v3", result.VersionID)
+	assert.False(t, result.VersionCreated)
+	assert.False(t, result.Changed())
+
+	trustPolicy := `{"Version":"2012-10-17","Statement":[]}`
+	aw.iam = &mockedIAMAPI{
+		getPolicyOut: &iam.GetPolicyOutput{
+			Policy: &iam.Policy{
+				Arn: aws.String("arn:aws:iam::123456789012:policy/my-policy"),
+			},
+		},
+		getRoleOut: &iam.GetRoleOutput{
+			Role: &iam.Role{
+				Path:                     aws.String("/"),
+				Arn:                      aws.String("arn:aws:iam::123456789012:role/my-role"),
+				AssumeRolePolicyDocument: aws.String(url.QueryEscape(trustPolicy)),
+				Tags:                     iamTags(desiredTags(nil)),
+			},
+		},
+		listAttachedRolePoliciesOut: &iam.ListAttachedRolePoliciesOutput{
+			AttachedPolicies: []*iam.AttachedPolicy{
+				{
+					PolicyArn: aws.String("arn:aws:iam::123456789012:policy/my-policy"),
+				},
+			},
+		},
+	}
+	result, err = aw.EnsureRole("my-role", []string{"my-policy"}, trustPolicy, RoleOptions{})
 	assert.NoError(t, err)
+	assert.Equal(t, "arn:aws:iam::123456789012:role/my-role", result.ARN)
+	assert.False(t, result.Changed())
 }
@@ -0,0 +1,79 @@
+package awswrapper
+
+import (
+	"log"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/pkg/errors"
+)
+
+// permissionsBoundaryARN resolves the permissions boundary reference, see
+// policyARN, and checks that the policy exists, since IAM would only fail
+// when creating or updating the role. An empty reference means no boundary.
+func (a *awsWrapper) permissionsBoundaryARN(boundary, path string) (string, error) {
+	if boundary == "" {
+		return "", nil
+	}
+	arn, err := a.policyARN(boundary, path)
+	if err != nil {
+		return "", errors.Wrapf(err, "permissions boundary")
+	}
+	_, err = a.iam.GetPolicy(&iam.GetPolicyInput{
+		PolicyArn: aws.String(arn),
+	})
+	if isNoSuchEntityError(err) {
+		return "", errors.Errorf("permissions boundary policy %s does not exist", arn)
+	}
+	if err != nil {
+		return "", errors.Wrapf(err, "get permissions boundary policy %s", arn)
+	}
+	return arn, nil
+}
+
+// ensurePermissionsBoundary sets the permissions boundary of an existing
+// role, or deletes it if desired is empty.
+func (a *awsWrapper) ensurePermissionsBoundary(roleName string, current *iam.AttachedPermissionsBoundary, desired string, result *EnsureResult) error {
+	var currentARN string
+	if current != nil {
+		currentARN = aws.StringValue(current.PermissionsBoundaryArn)
+	}
+	if currentARN == desired {
+		return nil
+	}
+	change := Change{
+		Action:   ChangeUpdate,
+		Resource: ResourcePermissionsBoundary,
+		Name:     roleName,
+		Before:   currentARN,
+		After:    desired,
+	}
+	if desired == "" {
+		change.Action = ChangeDelete
+		if !a.dryRun {
+			_, err := a.iam.DeleteRolePermissionsBoundary(&iam.DeleteRolePermissionsBoundaryInput{
+				RoleName: aws.String(roleName),
+			})
+			if err != nil {
+				return errors.Wrapf(err, "delete role %s permissions boundary", roleName)
+			}
+			log.Printf("Deleted role %s permissions boundary", roleName)
+		}
+	} else {
+		if currentARN == "" {
+			change.Action = ChangeCreate
+		}
+		if !a.dryRun {
+			_, err := a.iam.PutRolePermissionsBoundary(&iam.PutRolePermissionsBoundaryInput{
+				PermissionsBoundary: aws.String(desired),
+				RoleName:            aws.String(roleName),
+			})
+			if err != nil {
+				return errors.Wrapf(err, "put role %s permissions boundary", roleName)
+			}
+			log.Printf("Set role %s permissions boundary to %s", roleName, desired)
+		}
+	}
+	result.add(change)
+	return nil
+}
@@ -0,0 +1,129 @@
+package awswrapper
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPermissionsBoundaryARN(t *testing.T) {
+	testCases := []struct {
+		mock     *mockedIAMAPI
+		boundary string
+		arn      string
+		err      bool
+	}{
+		{
+			mock:     &mockedIAMAPI{getPolicyErr: fmt.Errorf("GetPolicy should not be called")},
+			boundary: "",
+			arn:      "",
+		},
+		{
+			mock:     &mockedIAMAPI{getPolicyOut: &iam.GetPolicyOutput{}},
+			boundary: "my-boundary",
+			arn:      "arn:aws:iam::123456789012:policy/my-boundary",
+		},
+		{
+			mock:     &mockedIAMAPI{getPolicyOut: &iam.GetPolicyOutput{}},
+			boundary: "arn:aws:iam::aws:policy/PowerUserAccess",
+			arn:      "arn:aws:iam::aws:policy/PowerUserAccess",
+		},
+		{
+			mock:     &mockedIAMAPI{getPolicyErr: awserr.New(iam.ErrCodeNoSuchEntityException, "", nil)},
+			boundary: "my-boundary",
+			err:      true,
+		},
+		{
+			mock:     &mockedIAMAPI{getPolicyErr: fmt.Errorf("GetPolicy test error")},
+			boundary: "my-boundary",
+			err:      true,
+		},
+		{
+			mock:     &mockedIAMAPI{getPolicyOut: &iam.GetPolicyOutput{}},
+			boundary: "arn:aws:iam::123456789012:role/my-role",
+			err:      true,
+		},
+	}
+	for _, tc := range testCases {
+		aw := awsWrapper{accountID: "123456789012", iam: tc.mock}
+		arn, err := aw.permissionsBoundaryARN(tc.boundary, "/")
+		if tc.err {
+			assert.Error(t, err, tc.boundary)
+			continue
+		}
+		assert.NoError(t, err, tc.boundary)
+		assert.Equal(t, tc.arn, arn)
+	}
+}
+
+func TestEnsurePermissionsBoundary(t *testing.T) {
+	boundary := func(arn string) *iam.AttachedPermissionsBoundary {
+		return &iam.AttachedPermissionsBoundary{
+			PermissionsBoundaryArn:  aws.String(arn),
+			PermissionsBoundaryType: aws.String(iam.PermissionsBoundaryAttachmentTypePermissionsBoundaryPolicy),
+		}
+	}
+	testCases := []struct {
+		mock    *mockedIAMAPI
+		current *iam.AttachedPermissionsBoundary
+		desired string
+		err     bool
+		changes []Change
+	}{
+		{
+			mock: mutationErrors(&mockedIAMAPI{}),
+		},
+		{
+			mock:    mutationErrors(&mockedIAMAPI{}),
+			current: boundary("arn:aws:iam::123456789012:policy/my-boundary"),
+			desired: "arn:aws:iam::123456789012:policy/my-boundary",
+		},
+		{
+			mock:    &mockedIAMAPI{putRolePermissionsBoundaryOut: &iam.PutRolePermissionsBoundaryOutput{}},
+			desired: "arn:aws:iam::123456789012:policy/my-boundary",
+			changes: []Change{
+				{Action: ChangeCreate, Resource: ResourcePermissionsBoundary, Name: "my-role", After: "arn:aws:iam::123456789012:policy/my-boundary"},
+			},
+		},
+		{
+			mock:    &mockedIAMAPI{putRolePermissionsBoundaryOut: &iam.PutRolePermissionsBoundaryOutput{}},
+			current: boundary("arn:aws:iam::123456789012:policy/my-old-boundary"),
+			desired: "arn:aws:iam::123456789012:policy/my-boundary",
+			changes: []Change{
+				{Action: ChangeUpdate, Resource: ResourcePermissionsBoundary, Name: "my-role", Before: "arn:aws:iam::123456789012:policy/my-old-boundary", After: "arn:aws:iam::123456789012:policy/my-boundary"},
+			},
+		},
+		{
+			mock:    &mockedIAMAPI{deleteRolePermissionsBoundaryOut: &iam.DeleteRolePermissionsBoundaryOutput{}},
+			current: boundary("arn:aws:iam::123456789012:policy/my-boundary"),
+			changes: []Change{
+				{Action: ChangeDelete, Resource: ResourcePermissionsBoundary, Name: "my-role", Before: "arn:aws:iam::123456789012:policy/my-boundary"},
+			},
+		},
+		{
+			mock:    &mockedIAMAPI{putRolePermissionsBoundaryErr: fmt.Errorf("PutRolePermissionsBoundary test error")},
+			desired: "arn:aws:iam::123456789012:policy/my-boundary",
+			err:     true,
+		},
+		{
+			mock:    &mockedIAMAPI{deleteRolePermissionsBoundaryErr: fmt.Errorf("DeleteRolePermissionsBoundary test error")},
+			current: boundary("arn:aws:iam::123456789012:policy/my-boundary"),
+			err:     true,
+		},
+	}
+	for i, tc := range testCases {
+		aw := awsWrapper{accountID: "123456789012", iam: tc.mock}
+		result := &EnsureResult{}
+		err := aw.ensurePermissionsBoundary("my-role", tc.current, tc.desired, result)
+		if tc.err {
+			assert.Error(t, err, i)
+			continue
+		}
+		assert.NoError(t, err, i)
+		assert.Equal(t, tc.changes, result.Changes, i)
+	}
+}
@@ -0,0 +1,192 @@
+package awswrapper
+
+import (
+	"log"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/pkg/errors"
+)
+
+// DeleteRole detaches all managed policies from the role, deletes its inline
+// policies, and deletes it. The policy is deleted too, with all its versions,
+// unless it is still attached to other roles, users or groups. If policyName
+// is empty, the policy is kept, otherwise it is looked up under policyPath.
+// Roles and policies not created by eks-iam-role are refused, see
+// ManagedByTagKey. Missing roles and policies are skipped, so deleting is
+// idempotent too.
+func (a *awsWrapper) DeleteRole(roleName, policyName, policyPath string) (*EnsureResult, error) {
+	log.Printf("Deleting role %s", roleName)
+	result := &EnsureResult{}
+	getRoleResult, err := a.iam.GetRole(&iam.GetRoleInput{
+		RoleName: aws.String(roleName),
+	})
+	if err != nil && !isNoSuchEntityError(err) {
+		return nil, errors.Wrapf(err, "get role %s", roleName)
+	}
+	roleExists := !isNoSuchEntityError(err)
+	var attached []*iam.AttachedPolicy
+	var inline []string
+	if roleExists {
+		if !isManaged(getRoleResult.Role.Tags) {
+			return nil, errors.Errorf("role %s is not managed by eks-iam-role, it has no %s=%s tag", roleName, ManagedByTagKey, ManagedByTagValue)
+		}
+		result.ARN = aws.StringValue(getRoleResult.Role.Arn)
+		listResult, err := a.iam.ListAttachedRolePolicies(&iam.ListAttachedRolePoliciesInput{
+			RoleName: aws.String(roleName),
+		})
+		if err != nil {
+			return nil, errors.Wrapf(err, "list role %s attached policies", roleName)
+		}
+		attached = listResult.AttachedPolicies
+		inline, err = a.listInlinePolicies(roleName)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		log.Printf("Role %s does not exist", roleName)
+	}
+	// The policy is checked before making any changes, so a policy that
+	// can't be deleted doesn't leave a half deleted role behind.
+	var policy *iam.Policy
+	if policyName != "" {
+		path, err := normalizePath(policyPath)
+		if err != nil {
+			return nil, errors.Wrapf(err, "policy %s", policyName)
+		}
+		policy, err = a.deletablePolicy(policyName, path, attached)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, p := range attached {
+		if err := a.detachRolePolicy(roleName, p, result); err != nil {
+			return nil, err
+		}
+	}
+	for _, name := range inline {
+		if err := a.deleteInlinePolicy(roleName, name, result); err != nil {
+			return nil, err
+		}
+	}
+	if policy != nil {
+		if err := a.deletePolicy(policyName, policy, result); err != nil {
+			return nil, err
+		}
+	}
+	if roleExists {
+		if !a.dryRun {
+			_, err := a.iam.DeleteRole(&iam.DeleteRoleInput{
+				RoleName: aws.String(roleName),
+			})
+			if err != nil {
+				return nil, errors.Wrapf(err, "delete role %s", roleName)
+			}
+			log.Printf("Deleted role %s", roleName)
+		}
+		result.add(Change{
+			Action:   ChangeDelete,
+			Resource: ResourceRole,
+			Name:     roleName,
+		})
+	}
+	return result, nil
+}
+
+func (a *awsWrapper) detachRolePolicy(roleName string, policy *iam.AttachedPolicy, result *EnsureResult) error {
+	if !a.dryRun {
+		_, err := a.iam.DetachRolePolicy(&iam.DetachRolePolicyInput{
+			PolicyArn: policy.PolicyArn,
+			RoleName:  aws.String(roleName),
+		})
+		if err != nil {
+			return errors.Wrapf(err, "detach policy %s from role %s", aws.StringValue(policy.PolicyName), roleName)
+		}
+		log.Printf("Detached policy %s from role %s", aws.StringValue(policy.PolicyName), roleName)
+	}
+	result.add(Change{
+		Action:   ChangeDetach,
+		Resource: ResourceRolePolicyAttachment,
+		Name:     roleName,
+		Before:   aws.StringValue(policy.PolicyArn),
+	})
+	return nil
+}
+
+// deletablePolicy returns the policy if it exists and can be deleted once it
+// is detached from the role, i.e. it was created by eks-iam-role and it is
+// not attached to anything else.
+func (a *awsWrapper) deletablePolicy(policyName, path string, attached []*iam.AttachedPolicy) (*iam.Policy, error) {
+	getResult, err := a.iam.GetPolicy(&iam.GetPolicyInput{
+		PolicyArn: a.arn("policy", path, policyName),
+	})
+	if isNoSuchEntityError(err) {
+		log.Printf("Policy %s does not exist", policyName)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "get policy %s", policyName)
+	}
+	policy := getResult.Policy
+	if !isManaged(policy.Tags) {
+		return nil, errors.Errorf("policy %s is not managed by eks-iam-role, it has no %s=%s tag", policyName, ManagedByTagKey, ManagedByTagValue)
+	}
+	attachments := aws.Int64Value(policy.AttachmentCount)
+	for _, p := range attached {
+		if aws.StringValue(p.PolicyArn) == aws.StringValue(policy.Arn) {
+			attachments--
+		}
+	}
+	if attachments > 0 {
+		log.Printf("Keeping policy %s, it is still attached to %d other entities", policyName, attachments)
+		return nil, nil
+	}
+	return policy, nil
+}
+
+// deletePolicy deletes the non-default versions of the policy, which IAM
+// requires before deleting the policy, and then the policy.
+func (a *awsWrapper) deletePolicy(policyName string, policy *iam.Policy, result *EnsureResult) error {
+	listResult, err := a.iam.ListPolicyVersions(&iam.ListPolicyVersionsInput{
+		PolicyArn: policy.Arn,
+	})
+	if err != nil {
+		return errors.Wrapf(err, "list policy %s versions", policyName)
+	}
+	for _, version := range listResult.Versions {
+		if aws.BoolValue(version.IsDefaultVersion) {
+			continue
+		}
+		if !a.dryRun {
+			_, err := a.iam.DeletePolicyVersion(&iam.DeletePolicyVersionInput{
+				PolicyArn: policy.Arn,
+				VersionId: version.VersionId,
+			})
+			if err != nil {
+				return errors.Wrapf(err, "delete policy %s version %s", policyName, aws.StringValue(version.VersionId))
+			}
+			log.Printf("Deleted policy version %s", aws.StringValue(version.VersionId))
+		}
+		result.add(Change{
+			Action:   ChangeDelete,
+			Resource: ResourcePolicyVersion,
+			Name:     policyName,
+			Before:   aws.StringValue(version.VersionId),
+		})
+	}
+	if !a.dryRun {
+		_, err := a.iam.DeletePolicy(&iam.DeletePolicyInput{
+			PolicyArn: policy.Arn,
+		})
+		if err != nil {
+			return errors.Wrapf(err, "delete policy %s", policyName)
+		}
+		log.Printf("Deleted policy %s", policyName)
+	}
+	result.add(Change{
+		Action:   ChangeDelete,
+		Resource: ResourcePolicy,
+		Name:     policyName,
+	})
+	return nil
+}
@@ -0,0 +1,245 @@
+package awswrapper
+
+import (
+	"fmt"
+	"net/url"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeleteRole(t *testing.T) {
+	policyARN := "arn:aws:iam::123456789012:policy/my-policy"
+	managedRole := &iam.GetRoleOutput{
+		Role: &iam.Role{
+			Arn:  aws.String("arn:aws:iam::123456789012:role/my-role"),
+			Tags: iamTags(desiredTags(nil)),
+		},
+	}
+	attachedPolicies := &iam.ListAttachedRolePoliciesOutput{
+		AttachedPolicies: []*iam.AttachedPolicy{
+			{
+				PolicyArn:  aws.String(policyARN),
+				PolicyName: aws.String("my-policy"),
+			},
+			{
+				PolicyArn:  aws.String("arn:aws:iam::aws:policy/ReadOnlyAccess"),
+				PolicyName: aws.String("ReadOnlyAccess"),
+			},
+		},
+	}
+	inlinePolicies := &iam.ListRolePoliciesOutput{
+		PolicyNames: aws.StringSlice([]string{"my-inline-policy"}),
+	}
+	managedPolicy := func(attachments int64) *iam.GetPolicyOutput {
+		return &iam.GetPolicyOutput{
+			Policy: &iam.Policy{
+				Arn:             aws.String(policyARN),
+				AttachmentCount: aws.Int64(attachments),
+				Tags:            iamTags(desiredTags(nil)),
+			},
+		}
+	}
+	policyVersions := &iam.ListPolicyVersionsOutput{
+		Versions: []*iam.PolicyVersion{
+			{
+				IsDefaultVersion: aws.Bool(true),
+				VersionId:        aws.String("v3"),
+			},
+			{
+				IsDefaultVersion: aws.Bool(false),
+				VersionId:        aws.String("v2"),
+			},
+			{
+				IsDefaultVersion: aws.Bool(false),
+				VersionId:        aws.String("v1"),
+			},
+		},
+	}
+	notFound := awserr.New(iam.ErrCodeNoSuchEntityException, "", nil)
+	testCases := []struct {
+		mock       *mockedIAMAPI
+		policyName string
+		dryRun     bool
+		err        bool
+		changes    []Change
+	}{
+		// Role and policy are deleted.
+		{
+			mock: &mockedIAMAPI{
+				getRoleOut:                  managedRole,
+				listAttachedRolePoliciesOut: attachedPolicies,
+				listRolePoliciesOut:         inlinePolicies,
+				getPolicyOut:                managedPolicy(1),
+				listPolicyVersionsOut:       policyVersions,
+			},
+			policyName: "my-policy",
+			changes: []Change{
+				{Action: ChangeDetach, Resource: ResourceRolePolicyAttachment, Name: "my-role", Before: policyARN},
+				{Action: ChangeDetach, Resource: ResourceRolePolicyAttachment, Name: "my-role", Before: "arn:aws:iam::aws:policy/ReadOnlyAccess"},
+				{Action: ChangeDelete, Resource: ResourceRolePolicy, Name: "my-role/my-inline-policy"},
+				{Action: ChangeDelete, Resource: ResourcePolicyVersion, Name: "my-policy", Before: "v2"},
+				{Action: ChangeDelete, Resource: ResourcePolicyVersion, Name: "my-policy", Before: "v1"},
+				{Action: ChangeDelete, Resource: ResourcePolicy, Name: "my-policy"},
+				{Action: ChangeDelete, Resource: ResourceRole, Name: "my-role"},
+			},
+		},
+		// Dry run.
+		{
+			mock: mutationErrors(&mockedIAMAPI{
+				getRoleOut:                  managedRole,
+				listAttachedRolePoliciesOut: attachedPolicies,
+				listRolePoliciesOut:         inlinePolicies,
+				getPolicyOut:                managedPolicy(1),
+				listPolicyVersionsOut:       &iam.ListPolicyVersionsOutput{},
+			}),
+			policyName: "my-policy",
+			dryRun:     true,
+			changes: []Change{
+				{Action: ChangeDetach, Resource: ResourceRolePolicyAttachment, Name: "my-role", Before: policyARN},
+				{Action: ChangeDetach, Resource: ResourceRolePolicyAttachment, Name: "my-role", Before: "arn:aws:iam::aws:policy/ReadOnlyAccess"},
+				{Action: ChangeDelete, Resource: ResourceRolePolicy, Name: "my-role/my-inline-policy"},
+				{Action: ChangeDelete, Resource: ResourcePolicy, Name: "my-policy"},
+				{Action: ChangeDelete, Resource: ResourceRole, Name: "my-role"},
+			},
+		},
+		// Policy is still attached elsewhere.
+		{
+			mock: &mockedIAMAPI{
+				getRoleOut:                  managedRole,
+				listAttachedRolePoliciesOut: attachedPolicies,
+				listRolePoliciesOut:         &iam.ListRolePoliciesOutput{},
+				getPolicyOut:                managedPolicy(2),
+				listPolicyVersionsErr:       fmt.Errorf("ListPolicyVersions test error"),
+			},
+			policyName: "my-policy",
+			changes: []Change{
+				{Action: ChangeDetach, Resource: ResourceRolePolicyAttachment, Name: "my-role", Before: policyARN},
+				{Action: ChangeDetach, Resource: ResourceRolePolicyAttachment, Name: "my-role", Before: "arn:aws:iam::aws:policy/ReadOnlyAccess"},
+				{Action: ChangeDelete, Resource: ResourceRole, Name: "my-role"},
+			},
+		},
+		// Policy is kept.
+		{
+			mock: &mockedIAMAPI{
+				getRoleOut:                  managedRole,
+				listAttachedRolePoliciesOut: &iam.ListAttachedRolePoliciesOutput{},
+				listRolePoliciesOut:         &iam.ListRolePoliciesOutput{},
+				getPolicyErr:                fmt.Errorf("GetPolicy test error"),
+			},
+			changes: []Change{
+				{Action: ChangeDelete, Resource: ResourceRole, Name: "my-role"},
+			},
+		},
+		// Role does not exist anymore, but its policy does.
+		{
+			mock: &mockedIAMAPI{
+				getRoleErr:            notFound,
+				getPolicyOut:          managedPolicy(0),
+				listPolicyVersionsOut: &iam.ListPolicyVersionsOutput{},
+			},
+			policyName: "my-policy",
+			changes: []Change{
+				{Action: ChangeDelete, Resource: ResourcePolicy, Name: "my-policy"},
+			},
+		},
+		// Nothing exists.
+		{
+			mock: &mockedIAMAPI{
+				getRoleErr:   notFound,
+				getPolicyErr: notFound,
+			},
+			policyName: "my-policy",
+		},
+		// Role was not created by eks-iam-role.
+		{
+			mock: mutationErrors(&mockedIAMAPI{
+				getRoleOut: &iam.GetRoleOutput{
+					Role: &iam.Role{
+						Arn: aws.String("arn:aws:iam::123456789012:role/my-role"),
+						Tags: []*iam.Tag{
+							{Key: aws.String(ManagedByTagKey), Value: aws.String("terraform")},
+						},
+					},
+				},
+			}),
+			policyName: "my-policy",
+			err:        true,
+		},
+		// Policy was not created by eks-iam-role.
+		{
+			mock: mutationErrors(&mockedIAMAPI{
+				getRoleOut:                  managedRole,
+				listAttachedRolePoliciesOut: attachedPolicies,
+				listRolePoliciesOut:         &iam.ListRolePoliciesOutput{},
+				getPolicyOut: &iam.GetPolicyOutput{
+					Policy: &iam.Policy{
+						Arn:             aws.String(policyARN),
+						AttachmentCount: aws.Int64(1),
+					},
+				},
+			}),
+			policyName: "my-policy",
+			err:        true,
+		},
+		// Role can't be deleted.
+		{
+			mock: &mockedIAMAPI{
+				getRoleOut:                  managedRole,
+				listAttachedRolePoliciesOut: &iam.ListAttachedRolePoliciesOutput{},
+				listRolePoliciesOut:         &iam.ListRolePoliciesOutput{},
+				deleteRoleErr:               fmt.Errorf("DeleteRole test error"),
+			},
+			err: true,
+		},
+	}
+	for i, tc := range testCases {
+		aw := awsWrapper{accountID: "123456789012", dryRun: tc.dryRun, iam: tc.mock}
+		result, err := aw.DeleteRole("my-role", tc.policyName, "")
+		if tc.err {
+			assert.Error(t, err, i)
+			continue
+		}
+		assert.NoError(t, err, i)
+		assert.Equal(t, tc.changes, result.Changes, i)
+	}
+}
+
+// TestDeleteAdoptedRole checks that roles created outside of eks-iam-role
+// can't be deleted after ensuring them.
+func TestDeleteAdoptedRole(t *testing.T) {
+	trustPolicy := `{"Version": "2012-10-17", "Statement": [{"Effect": "Allow", "Principal": {"Federated": "arn:aws:iam::123456789012:oidc-provider/my-issuer"}, "Action": "sts:AssumeRoleWithWebIdentity"}]}`
+	mock := &mockedIAMAPI{
+		getRoleOut: &iam.GetRoleOutput{
+			Role: &iam.Role{
+				Arn:                      aws.String("arn:aws:iam::123456789012:role/my-role"),
+				Path:                     aws.String("/"),
+				AssumeRolePolicyDocument: aws.String(url.QueryEscape(trustPolicy)),
+			},
+		},
+		listAttachedRolePoliciesOut: &iam.ListAttachedRolePoliciesOutput{
+			AttachedPolicies: []*iam.AttachedPolicy{
+				{
+					PolicyArn:  aws.String("arn:aws:iam::123456789012:policy/my-policy"),
+					PolicyName: aws.String("my-policy"),
+				},
+			},
+		},
+		listRolePoliciesOut: &iam.ListRolePoliciesOutput{},
+		tagRoleOut:          &iam.TagRoleOutput{},
+	}
+	aw := awsWrapper{accountID: "123456789012", iam: mock}
+	result, err := aw.EnsureRole("my-role", []string{"my-policy"}, trustPolicy, RoleOptions{
+		Tags: map[string]string{"team": "platform"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []Change{
+		{Action: ChangeUpdate, Resource: ResourceRoleTags, Name: "my-role", After: "team=platform"},
+	}, result.Changes)
+	mock.deleteRoleErr = fmt.Errorf("DeleteRole should not be called")
+	_, err = aw.DeleteRole("my-role", "my-policy", "")
+	assert.Error(t, err)
+}
@@ -0,0 +1,141 @@
+package awswrapper
+
+import (
+	"encoding/json"
+	"log"
+	"sort"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/pkg/errors"
+)
+
+// parseInlinePolicies parses the documents of inline policies by name.
+func parseInlinePolicies(policies map[string][]byte) (map[string]*PolicyDocument, error) {
+	docs := make(map[string]*PolicyDocument, len(policies))
+	for name, buf := range policies {
+		if !policyNameRegexp.MatchString(name) {
+			return nil, errors.Errorf("invalid inline policy name %q", name)
+		}
+		doc, err := ParsePolicyDocument(buf)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parsing inline policy %s", name)
+		}
+		docs[name] = doc
+	}
+	return docs, nil
+}
+
+// inlinePolicyName returns the name of an inline policy used in changes,
+// since inline policies are only unique per role.
+func inlinePolicyName(roleName, policyName string) string {
+	return roleName + "/" + policyName
+}
+
+// ensureInlinePolicies puts the inline policies into the role, unless an
+// equivalent document is there already. Other inline policies are deleted if
+// exclusive is set.
+func (a *awsWrapper) ensureInlinePolicies(roleName string, policies map[string]*PolicyDocument, roleExists, exclusive bool, result *EnsureResult) error {
+	names := make([]string, 0, len(policies))
+	for name := range policies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		desired := policies[name]
+		var current *PolicyDocument
+		// In dry-run mode the role might not have been created, and there
+		// are no inline policies to look up.
+		if roleExists {
+			getResult, err := a.iam.GetRolePolicy(&iam.GetRolePolicyInput{
+				PolicyName: aws.String(name),
+				RoleName:   aws.String(roleName),
+			})
+			if err != nil && !isNoSuchEntityError(err) {
+				return errors.Wrapf(err, "get role %s inline policy %s", roleName, name)
+			}
+			if err == nil {
+				current, err = decodePolicyDocument(aws.StringValue(getResult.PolicyDocument))
+				if err != nil {
+					return errors.Wrapf(err, "parsing role %s inline policy %s", roleName, name)
+				}
+			}
+		}
+		change := Change{
+			Action:   ChangeCreate,
+			Resource: ResourceRolePolicy,
+			Name:     inlinePolicyName(roleName, name),
+			After:    formatPolicy(desired),
+		}
+		if current != nil {
+			if PoliciesEquivalent(current, desired) {
+				log.Printf("Existing inline policy %s of role %s matches requested policy", name, roleName)
+				continue
+			}
+			log.Printf("Existing inline policy %s of role %s does not match requested policy:\n%s", name, roleName, policyDiff(current, desired))
+			change.Action = ChangeUpdate
+			change.Before = formatPolicy(current)
+		}
+		if !a.dryRun {
+			buf, err := json.Marshal(desired)
+			if err != nil {
+				return errors.Wrapf(err, "serializing inline policy %s", name)
+			}
+			_, err = a.iam.PutRolePolicy(&iam.PutRolePolicyInput{
+				PolicyDocument: aws.String(string(buf)),
+				PolicyName:     aws.String(name),
+				RoleName:       aws.String(roleName),
+			})
+			if err != nil {
+				return errors.Wrapf(err, "put role %s inline policy %s", roleName, name)
+			}
+			log.Printf("Put inline policy %s into role %s", name, roleName)
+		}
+		result.add(change)
+	}
+	if !roleExists || !exclusive {
+		return nil
+	}
+	current, err := a.listInlinePolicies(roleName)
+	if err != nil {
+		return err
+	}
+	for _, name := range current {
+		if _, ok := policies[name]; ok {
+			continue
+		}
+		if err := a.deleteInlinePolicy(roleName, name, result); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *awsWrapper) listInlinePolicies(roleName string) ([]string, error) {
+	listResult, err := a.iam.ListRolePolicies(&iam.ListRolePoliciesInput{
+		RoleName: aws.String(roleName),
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "list role %s inline policies", roleName)
+	}
+	return aws.StringValueSlice(listResult.PolicyNames), nil
+}
+
+func (a *awsWrapper) deleteInlinePolicy(roleName, name string, result *EnsureResult) error {
+	if !a.dryRun {
+		_, err := a.iam.DeleteRolePolicy(&iam.DeleteRolePolicyInput{
+			PolicyName: aws.String(name),
+			RoleName:   aws.String(roleName),
+		})
+		if err != nil {
+			return errors.Wrapf(err, "delete role %s inline policy %s", roleName, name)
+		}
+		log.Printf("Deleted inline policy %s of role %s", name, roleName)
+	}
+	result.add(Change{
+		Action:   ChangeDelete,
+		Resource: ResourceRolePolicy,
+		Name:     inlinePolicyName(roleName, name),
+	})
+	return nil
+}
@@ -0,0 +1,118 @@
+package awswrapper
+
+import (
+	"fmt"
+	"net/url"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseInlinePolicies(t *testing.T) {
+	docs, err := parseInlinePolicies(map[string][]byte{
+		"my-inline-policy": []byte(`{"Version": "2012-10-17", "Statement": []}`),
+	})
+	assert.NoError(t, err)
+	assert.Contains(t, docs, "my-inline-policy")
+
+	_, err = parseInlinePolicies(map[string][]byte{
+		"my inline policy": []byte(`{"Version": "2012-10-17", "Statement": []}`),
+	})
+	assert.Error(t, err)
+
+	_, err = parseInlinePolicies(map[string][]byte{
+		"my-inline-policy": []byte(`invalid`),
+	})
+	assert.Error(t, err)
+}
+
+func TestEnsureInlinePolicies(t *testing.T) {
+	doc := `{"Version": "2012-10-17", "Statement": [{"Effect": "Allow", "Action": "s3:GetObject", "Resource": "*"}]}`
+	formattedDoc := "{\n  \"Version\": \"2012-10-17\",\n  \"Statement\": [\n    {\n      \"Effect\": \"Allow\",\n      \"Action\": \"s3:getobject\",\n      \"Resource\": \"*\"\n    }\n  ]\n}"
+	testCases := []struct {
+		mock       *mockedIAMAPI
+		dryRun     bool
+		roleExists bool
+		exclusive  bool
+		err        bool
+		changes    []Change
+	}{
+		// Role would be created.
+		{
+			mock:    mutationErrors(&mockedIAMAPI{}),
+			dryRun:  true,
+			changes: []Change{{Action: ChangeCreate, Resource: ResourceRolePolicy, Name: "my-role/my-inline-policy", After: formattedDoc}},
+		},
+		// Inline policy does not exist.
+		{
+			mock: &mockedIAMAPI{
+				getRolePolicyErr: awserr.New(iam.ErrCodeNoSuchEntityException, "", nil),
+				putRolePolicyOut: &iam.PutRolePolicyOutput{},
+			},
+			roleExists: true,
+			changes:    []Change{{Action: ChangeCreate, Resource: ResourceRolePolicy, Name: "my-role/my-inline-policy", After: formattedDoc}},
+		},
+		// Inline policy exists with an equivalent, reformatted document, and
+		// another inline policy is kept.
+		{
+			mock: mutationErrors(&mockedIAMAPI{
+				getRolePolicyOut: &iam.GetRolePolicyOutput{
+					PolicyDocument: aws.String(url.QueryEscape(`{"Statement":[{"Action":["s3:getobject"],"Effect":"Allow","Resource":"*"}],"Version":"2012-10-17"}`)),
+				},
+				listRolePoliciesErr: fmt.Errorf("ListRolePolicies should not be called"),
+			}),
+			roleExists: true,
+		},
+		// Inline policy exists with a different document, and another
+		// inline policy is deleted.
+		{
+			mock: &mockedIAMAPI{
+				getRolePolicyOut: &iam.GetRolePolicyOutput{
+					PolicyDocument: aws.String(url.QueryEscape(`{"Version": "2012-10-17"}`)),
+				},
+				listRolePoliciesOut: &iam.ListRolePoliciesOutput{
+					PolicyNames: aws.StringSlice([]string{"my-inline-policy", "my-old-inline-policy"}),
+				},
+				putRolePolicyOut:    &iam.PutRolePolicyOutput{},
+				deleteRolePolicyOut: &iam.DeleteRolePolicyOutput{},
+			},
+			roleExists: true,
+			exclusive:  true,
+			changes: []Change{
+				{Action: ChangeUpdate, Resource: ResourceRolePolicy, Name: "my-role/my-inline-policy", Before: "{\n  \"Version\": \"2012-10-17\"\n}", After: formattedDoc},
+				{Action: ChangeDelete, Resource: ResourceRolePolicy, Name: "my-role/my-old-inline-policy"},
+			},
+		},
+		{
+			mock: &mockedIAMAPI{
+				getRolePolicyErr: fmt.Errorf("GetRolePolicy test error"),
+			},
+			roleExists: true,
+			err:        true,
+		},
+		{
+			mock: &mockedIAMAPI{
+				getRolePolicyErr: awserr.New(iam.ErrCodeNoSuchEntityException, "", nil),
+				putRolePolicyErr: fmt.Errorf("PutRolePolicy test error"),
+			},
+			roleExists: true,
+			err:        true,
+		},
+	}
+	for i, tc := range testCases {
+		aw := awsWrapper{accountID: "123456789012", dryRun: tc.dryRun, iam: tc.mock}
+		policies, err := parseInlinePolicies(map[string][]byte{"my-inline-policy": []byte(doc)})
+		assert.NoError(t, err)
+		result := &EnsureResult{}
+		err = aw.ensureInlinePolicies("my-role", policies, tc.roleExists, tc.exclusive, result)
+		if tc.err {
+			assert.Error(t, err, i)
+			continue
+		}
+		assert.NoError(t, err, i)
+		assert.Equal(t, tc.changes, result.Changes, i)
+	}
+}
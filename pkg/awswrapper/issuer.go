@@ -0,0 +1,62 @@
+package awswrapper
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	hostnameLabelRegexp = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?$`)
+	pathSegmentRegexp   = regexp.MustCompile(`^[A-Za-z0-9._~-]+$`)
+)
+
+// NormalizeOIDCIssuer turns an OIDC issuer URL, e.g.
+// "https://oidc.eks.us-east-1.amazonaws.com/id/EXAMPLE/", into the form IAM
+// uses in OIDC provider ARNs and condition keys, e.g.
+// "oidc.eks.us-east-1.amazonaws.com/id/EXAMPLE". The scheme is optional, but
+// if it is present it has to be https.
+func NormalizeOIDCIssuer(issuer string) (string, error) {
+	raw := strings.TrimSpace(issuer)
+	if raw == "" {
+		return "", errors.New("empty OIDC issuer")
+	}
+	if !strings.Contains(raw, "://") {
+		raw = "https://" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", errors.Wrapf(err, "invalid OIDC issuer %q", issuer)
+	}
+	if u.Scheme != "https" {
+		return "", errors.Errorf("invalid OIDC issuer %q: scheme must be https", issuer)
+	}
+	if u.User != nil || u.RawQuery != "" || u.Fragment != "" || u.Opaque != "" {
+		return "", errors.Errorf("invalid OIDC issuer %q: only host and path are allowed", issuer)
+	}
+	if u.Port() != "" {
+		return "", errors.Errorf("invalid OIDC issuer %q: port is not allowed", issuer)
+	}
+	host := strings.ToLower(u.Hostname())
+	if host == "" || len(host) > 253 {
+		return "", errors.Errorf("invalid OIDC issuer %q: invalid host", issuer)
+	}
+	for _, label := range strings.Split(host, ".") {
+		if len(label) > 63 || !hostnameLabelRegexp.MatchString(label) {
+			return "", errors.Errorf("invalid OIDC issuer %q: invalid host", issuer)
+		}
+	}
+	normalized := host
+	path := strings.TrimRight(u.Path, "/")
+	if path != "" {
+		for _, segment := range strings.Split(strings.TrimPrefix(path, "/"), "/") {
+			if segment == "." || segment == ".." || !pathSegmentRegexp.MatchString(segment) {
+				return "", errors.Errorf("invalid OIDC issuer %q: invalid path", issuer)
+			}
+		}
+		normalized += path
+	}
+	return normalized, nil
+}
@@ -0,0 +1,89 @@
+package awswrapper
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeOIDCIssuer(t *testing.T) {
+	testCases := []struct {
+		issuer   string
+		expected string
+		err      bool
+	}{
+		{
+			issuer:   "https://oidc.eks.us-east-1.amazonaws.com/id/EXAMPLED539D4633E53DE1B71EXAMPLE",
+			expected: "oidc.eks.us-east-1.amazonaws.com/id/EXAMPLED539D4633E53DE1B71EXAMPLE",
+		},
+		{
+			issuer:   "https://oidc.eks.us-east-1.amazonaws.com/id/EXAMPLED539D4633E53DE1B71EXAMPLE/",
+			expected: "oidc.eks.us-east-1.amazonaws.com/id/EXAMPLED539D4633E53DE1B71EXAMPLE",
+		},
+		{
+			issuer:   "oidc.eks.us-east-1.amazonaws.com/id/EXAMPLED539D4633E53DE1B71EXAMPLE",
+			expected: "oidc.eks.us-east-1.amazonaws.com/id/EXAMPLED539D4633E53DE1B71EXAMPLE",
+		},
+		{
+			issuer:   " HTTPS://OIDC.example.com/ ",
+			expected: "oidc.example.com",
+		},
+		{
+			issuer:   "my-issuer",
+			expected: "my-issuer",
+		},
+		{
+			issuer: "",
+			err:    true,
+		},
+		{
+			issuer: "http://oidc.example.com",
+			err:    true,
+		},
+		{
+			issuer: "https://oidc.example.com:8443/id/1",
+			err:    true,
+		},
+		{
+			issuer: "https://user@oidc.example.com/id/1",
+			err:    true,
+		},
+		{
+			issuer: "https://oidc.example.com/id/1?query=1",
+			err:    true,
+		},
+		{
+			issuer: "https://oidc.example.com/id/1#fragment",
+			err:    true,
+		},
+		{
+			issuer: "https://oidc..example.com/id/1",
+			err:    true,
+		},
+		{
+			issuer: "https://oidc_example.com/id/1",
+			err:    true,
+		},
+		{
+			issuer: "https://oidc.example.com/id//1",
+			err:    true,
+		},
+		{
+			issuer: "https://oidc.example.com/id/../1",
+			err:    true,
+		},
+		{
+			issuer: "https:///id/1",
+			err:    true,
+		},
+	}
+	for _, tc := range testCases {
+		issuer, err := NormalizeOIDCIssuer(tc.issuer)
+		if tc.err {
+			assert.Error(t, err, tc.issuer)
+		} else {
+			assert.NoError(t, err, tc.issuer)
+			assert.Equal(t, tc.expected, issuer)
+		}
+	}
+}
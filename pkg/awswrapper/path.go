@@ -0,0 +1,27 @@
+package awswrapper
+
+import (
+	"regexp"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// maxPathLength is the IAM limit for role and policy paths.
+	maxPathLength = 512
+)
+
+var pathRegexp = regexp.MustCompile(`^/([\x21-\x7e]+/)?$`)
+
+// normalizePath checks the syntax of an IAM path, e.g. "/eks/my-cluster/",
+// which has to start and end with a slash. An empty path is the root path
+// "/".
+func normalizePath(path string) (string, error) {
+	if path == "" {
+		return "/", nil
+	}
+	if len(path) > maxPathLength || !pathRegexp.MatchString(path) {
+		return "", errors.Errorf("invalid path %q, it has to start and end with /", path)
+	}
+	return path, nil
+}
@@ -0,0 +1,104 @@
+package awswrapper
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizePath(t *testing.T) {
+	testCases := []struct {
+		path     string
+		expected string
+		err      bool
+	}{
+		{path: "", expected: "/"},
+		{path: "/", expected: "/"},
+		{path: "/eks/", expected: "/eks/"},
+		{path: "/eks/my-cluster/", expected: "/eks/my-cluster/"},
+		{path: "eks/", err: true},
+		{path: "/eks", err: true},
+		{path: "/eks my-cluster/", err: true},
+		{path: "//", err: true},
+		{path: "/" + strings.Repeat("a", maxPathLength) + "/", err: true},
+	}
+	for _, tc := range testCases {
+		path, err := normalizePath(tc.path)
+		if tc.err {
+			assert.Error(t, err, tc.path)
+			continue
+		}
+		assert.NoError(t, err, tc.path)
+		assert.Equal(t, tc.expected, path)
+	}
+}
+
+func TestPaths(t *testing.T) {
+	aw := awsWrapper{
+		accountID: "123456789012",
+		dryRun:    true,
+		iam: mutationErrors(&mockedIAMAPI{
+			getPolicyErr: awserr.New(iam.ErrCodeNoSuchEntityException, "", nil),
+			getRoleOut: &iam.GetRoleOutput{
+				Role: &iam.Role{
+					Path: aws.String("/"),
+				},
+			},
+		}),
+	}
+	result, err := aw.EnsurePolicy("my-policy", []byte(`{}`), PolicyOptions{Path: "/eks/my-cluster/"})
+	assert.NoError(t, err)
+	assert.Equal(t, "arn:aws:iam::123456789012:policy/eks/my-cluster/my-policy", result.ARN)
+
+	_, err = aw.EnsurePolicy("my-policy", []byte(`{}`), PolicyOptions{Path: "eks"})
+	assert.Error(t, err)
+
+	arns, err := aw.policyARNs([]string{"my-policy", "arn:aws:iam::aws:policy/ReadOnlyAccess"}, "/eks/")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"arn:aws:iam::123456789012:policy/eks/my-policy", "arn:aws:iam::aws:policy/ReadOnlyAccess"}, arns)
+
+	// The path of an existing role can't be changed.
+	_, err = aw.EnsureRole("my-role", nil, `{}`, RoleOptions{Path: "/eks/"})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "paths can't be changed")
+	}
+
+	_, err = aw.EnsureRole("my-role", nil, `{}`, RoleOptions{PolicyPath: "eks"})
+	assert.Error(t, err)
+}
+
+func TestCreateWithPaths(t *testing.T) {
+	mock := &mockedIAMAPI{
+		attachRolePolicyOut: &iam.AttachRolePolicyOutput{},
+		createPolicyOut: &iam.CreatePolicyOutput{
+			Policy: &iam.Policy{
+				Arn: aws.String("arn:aws:iam::123456789012:policy/eks/my-policy"),
+			},
+		},
+		createRoleOut: &iam.CreateRoleOutput{
+			Role: &iam.Role{
+				Arn: aws.String("arn:aws:iam::123456789012:role/eks/my-role"),
+			},
+		},
+		getPolicyErr:                awserr.New(iam.ErrCodeNoSuchEntityException, "", nil),
+		getRoleErr:                  awserr.New(iam.ErrCodeNoSuchEntityException, "", nil),
+		listAttachedRolePoliciesOut: &iam.ListAttachedRolePoliciesOutput{},
+	}
+	aw := awsWrapper{accountID: "123456789012", iam: mock}
+	_, err := aw.EnsurePolicy("my-policy", []byte(`{}`), PolicyOptions{Path: "/eks/"})
+	assert.NoError(t, err)
+	if assert.NotNil(t, mock.createPolicyIn) {
+		assert.Equal(t, "/eks/", aws.StringValue(mock.createPolicyIn.Path))
+	}
+
+	result, err := aw.EnsureRole("my-role", []string{"my-policy"}, `{}`, RoleOptions{Path: "/eks/", PolicyPath: "/eks/"})
+	assert.NoError(t, err)
+	if assert.NotNil(t, mock.createRoleIn) {
+		assert.Equal(t, "/eks/", aws.StringValue(mock.createRoleIn.Path))
+	}
+	assert.Equal(t, "arn:aws:iam::123456789012:role/eks/my-role", result.ARN)
+}
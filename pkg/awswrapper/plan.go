@@ -0,0 +1,83 @@
+package awswrapper
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/ldx/eks_iam_role/pkg/diff"
+)
+
+type ChangeAction string
+
+const (
+	ChangeCreate ChangeAction = "create"
+	ChangeUpdate ChangeAction = "update"
+	ChangeAttach ChangeAction = "attach"
+	ChangeDelete ChangeAction = "delete"
+	ChangeDetach ChangeAction = "detach"
+)
+
+const (
+	ResourcePolicy               = "policy"
+	ResourcePolicyVersion        = "policy-version"
+	ResourceRole                 = "role"
+	ResourceTrustPolicy          = "trust-policy"
+	ResourceRolePolicyAttachment = "role-policy-attachment"
+	ResourceRolePolicy           = "role-policy"
+	ResourcePermissionsBoundary  = "permissions-boundary"
+	ResourceRoleDescription      = "role-description"
+	ResourceMaxSessionDuration   = "max-session-duration"
+	ResourceRoleTags             = "role-tags"
+	ResourcePolicyTags           = "policy-tags"
+)
+
+// Change is a mutating IAM call, either made or, in dry-run mode, planned.
+// Before and After hold the canonical policy documents for policy and trust
+// policy changes, the policy version ID for deleted policy versions, and the
+// policy ARN for attachments and detachments, and the values of role
+// descriptions and maximum session durations.
+type Change struct {
+	Action   ChangeAction `json:"action"`
+	Resource string       `json:"resource"`
+	Name     string       `json:"name"`
+	Before   string       `json:"before,omitempty"`
+	After    string       `json:"after,omitempty"`
+}
+
+// EnsureResult is the outcome of EnsurePolicy, EnsureRole or DeleteRole.
+type EnsureResult struct {
+	// ARN of the policy or role. It is empty for roles that would be
+	// created in dry-run mode.
+	ARN string `json:"arn,omitempty"`
+	// VersionID is the default version of the policy.
+	VersionID string `json:"versionId,omitempty"`
+	// VersionCreated is set if VersionID was created by EnsurePolicy.
+	VersionCreated bool     `json:"versionCreated,omitempty"`
+	Changes        []Change `json:"changes"`
+}
+
+func (r *EnsureResult) add(change Change) {
+	r.Changes = append(r.Changes, change)
+}
+
+// Changed reports whether anything was, or in dry-run mode would be, changed.
+func (r *EnsureResult) Changed() bool {
+	return len(r.Changes) > 0
+}
+
+// formatPolicy returns the canonical form of the document, indented for
+// displaying it.
+func formatPolicy(doc *PolicyDocument) string {
+	buf, err := json.MarshalIndent(doc.Canonical(), "", "  ")
+	if err != nil {
+		// Can't happen, documents only contain marshalable types.
+		panic(err)
+	}
+	return string(buf)
+}
+
+// policyDiff returns the diff of the canonical forms of the documents, for
+// logging. It is colorized if the log goes to a terminal.
+func policyDiff(current, desired *PolicyDocument) string {
+	return diff.Unified(formatPolicy(current), formatPolicy(desired), "current", "desired", diff.ColorEnabled(os.Stderr))
+}
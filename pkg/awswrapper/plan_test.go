@@ -0,0 +1,482 @@
+package awswrapper
+
+import (
+	"fmt"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/stretchr/testify/assert"
+)
+
+// mutationErrors makes every mutating IAM call of the mock fail.
+func mutationErrors(m *mockedIAMAPI) *mockedIAMAPI {
+	err := fmt.Errorf("mutating call in dry-run mode")
+	m.attachRolePolicyErr = err
+	m.createPolicyErr = err
+	m.createPolicyVersionErr = err
+	m.createRoleErr = err
+	m.deletePolicyErr = err
+	m.deletePolicyVersionErr = err
+	m.deleteRoleErr = err
+	m.deleteRolePermissionsBoundaryErr = err
+	m.deleteRolePolicyErr = err
+	m.detachRolePolicyErr = err
+	m.putRolePermissionsBoundaryErr = err
+	m.putRolePolicyErr = err
+	m.tagPolicyErr = err
+	m.tagRoleErr = err
+	m.untagPolicyErr = err
+	m.untagRoleErr = err
+	m.updateAssumeRolePolicyErr = err
+	m.updateRoleErr = err
+	return m
+}
+
+func TestEnsurePolicyDryRun(t *testing.T) {
+	doc := `{"Version": "2012-10-17", "Statement": [{"Effect": "Allow", "Action": "s3:GetObject", "Resource": "*"}]}`
+	testCases := []struct {
+		mock    *mockedIAMAPI
+		changes []Change
+	}{
+		// Policy does not exist.
+		{
+			mock: mutationErrors(&mockedIAMAPI{
+				getPolicyErr: awserr.New(iam.ErrCodeNoSuchEntityException, "", nil),
+			}),
+			changes: []Change{
+				{
+					Action:   ChangeCreate,
+					Resource: ResourcePolicy,
+					Name:     "my-policy",
+					After:    "{\n  \"Version\": \"2012-10-17\",\n  \"Statement\": [\n    {\n      \"Effect\": \"Allow\",\n      \"Action\": \"s3:getobject\",\n      \"Resource\": \"*\"\n    }\n  ]\n}",
+				},
+			},
+		},
+		// Policy exists with an equivalent document.
+		{
+			mock: mutationErrors(&mockedIAMAPI{
+				getPolicyOut: &iam.GetPolicyOutput{
+					Policy: &iam.Policy{
+						DefaultVersionId: aws.String("v1"),
+						Tags:             iamTags(desiredTags(nil)),
+					},
+				},
+				getPolicyVersionOut: &iam.GetPolicyVersionOutput{
+					PolicyVersion: &iam.PolicyVersion{
+						Document: aws.String(url.QueryEscape(doc)),
+					},
+				},
+			}),
+			changes: nil,
+		},
+		// Policy exists with a different document and an old version.
+		{
+			mock: mutationErrors(&mockedIAMAPI{
+				getPolicyOut: &iam.GetPolicyOutput{
+					Policy: &iam.Policy{
+						DefaultVersionId: aws.String("v2"),
+						Tags:             iamTags(desiredTags(nil)),
+					},
+				},
+				getPolicyVersionOut: &iam.GetPolicyVersionOutput{
+					PolicyVersion: &iam.PolicyVersion{
+						Document: aws.String(url.QueryEscape(`{"Version": "2012-10-17"}`)),
+					},
+				},
+				listPolicyVersionsOut: &iam.ListPolicyVersionsOutput{
+					Versions: []*iam.PolicyVersion{
+						{
+							IsDefaultVersion: aws.Bool(true),
+							VersionId:        aws.String("v2"),
+						},
+						{
+							IsDefaultVersion: aws.Bool(false),
+							VersionId:        aws.String("v1"),
+						},
+					},
+				},
+			}),
+			changes: []Change{
+				{
+					Action:   ChangeDelete,
+					Resource: ResourcePolicyVersion,
+					Name:     "my-policy",
+					Before:   "v1",
+				},
+				{
+					Action:   ChangeUpdate,
+					Resource: ResourcePolicy,
+					Name:     "my-policy",
+					Before:   "{\n  \"Version\": \"2012-10-17\"\n}",
+					After:    "{\n  \"Version\": \"2012-10-17\",\n  \"Statement\": [\n    {\n      \"Effect\": \"Allow\",\n      \"Action\": \"s3:getobject\",\n      \"Resource\": \"*\"\n    }\n  ]\n}",
+				},
+			},
+		},
+	}
+	for _, tc := range testCases {
+		aw := awsWrapper{accountID: "123456789012", dryRun: true, iam: tc.mock}
+		result, err := aw.EnsurePolicy("my-policy", []byte(doc), PolicyOptions{})
+		assert.NoError(t, err)
+		assert.Equal(t, tc.changes, result.Changes)
+		assert.Equal(t, len(tc.changes) > 0, result.Changed())
+	}
+}
+
+func TestEnsureRoleDryRun(t *testing.T) {
+	trustPolicy := `{"Version": "2012-10-17", "Statement": [{"Effect": "Allow", "Principal": {"Federated": "arn:aws:iam::123456789012:oidc-provider/my-issuer"}, "Action": "sts:AssumeRoleWithWebIdentity"}]}`
+	formattedTrustPolicy := "{\n  \"Version\": \"2012-10-17\",\n  \"Statement\": [\n    {\n      \"Effect\": \"Allow\",\n      \"Principal\": {\n        \"Federated\": \"arn:aws:iam::123456789012:oidc-provider/my-issuer\"\n      },\n      \"Action\": \"sts:assumerolewithwebidentity\"\n    }\n  ]\n}"
+	testCases := []struct {
+		mock    *mockedIAMAPI
+		opts    RoleOptions
+		changes []Change
+	}{
+		// Role does not exist, attached policies can't be listed.
+		{
+			mock: mutationErrors(&mockedIAMAPI{
+				getRoleErr:                  awserr.New(iam.ErrCodeNoSuchEntityException, "", nil),
+				listAttachedRolePoliciesErr: awserr.New(iam.ErrCodeNoSuchEntityException, "", nil),
+			}),
+			changes: []Change{
+				{
+					Action:   ChangeCreate,
+					Resource: ResourceRole,
+					Name:     "my-role",
+					After:    formattedTrustPolicy,
+				},
+				{
+					Action:   ChangeAttach,
+					Resource: ResourceRolePolicyAttachment,
+					Name:     "my-role",
+					After:    "arn:aws:iam::123456789012:policy/my-policy",
+				},
+			},
+		},
+		// Role does not exist, and gets a permissions boundary.
+		{
+			mock: mutationErrors(&mockedIAMAPI{
+				getPolicyOut: &iam.GetPolicyOutput{},
+				getRoleErr:   awserr.New(iam.ErrCodeNoSuchEntityException, "", nil),
+			}),
+			opts: RoleOptions{
+				PermissionsBoundary: "my-boundary",
+			},
+			changes: []Change{
+				{
+					Action:   ChangeCreate,
+					Resource: ResourceRole,
+					Name:     "my-role",
+					After:    formattedTrustPolicy,
+				},
+				{
+					Action:   ChangeCreate,
+					Resource: ResourcePermissionsBoundary,
+					Name:     "my-role",
+					After:    "arn:aws:iam::123456789012:policy/my-boundary",
+				},
+				{
+					Action:   ChangeAttach,
+					Resource: ResourceRolePolicyAttachment,
+					Name:     "my-role",
+					After:    "arn:aws:iam::123456789012:policy/my-policy",
+				},
+			},
+		},
+		// Role exists with a permissions boundary, which is kept if no
+		// boundary is given.
+		{
+			mock: mutationErrors(&mockedIAMAPI{
+				getPolicyErr: fmt.Errorf("GetPolicy should not be called"),
+				getRoleOut: &iam.GetRoleOutput{
+					Role: &iam.Role{
+						Path:                     aws.String("/"),
+						AssumeRolePolicyDocument: aws.String(url.QueryEscape(trustPolicy)),
+						PermissionsBoundary: &iam.AttachedPermissionsBoundary{
+							PermissionsBoundaryArn: aws.String("arn:aws:iam::123456789012:policy/my-boundary"),
+						},
+						Tags: iamTags(desiredTags(nil)),
+					},
+				},
+				listAttachedRolePoliciesOut: &iam.ListAttachedRolePoliciesOutput{
+					AttachedPolicies: []*iam.AttachedPolicy{
+						{
+							PolicyArn: aws.String("arn:aws:iam::123456789012:policy/my-policy"),
+						},
+					},
+				},
+			}),
+			changes: nil,
+		},
+		// Role exists with a permissions boundary, which is removed on
+		// request.
+		{
+			mock: mutationErrors(&mockedIAMAPI{
+				getRoleOut: &iam.GetRoleOutput{
+					Role: &iam.Role{
+						Path:                     aws.String("/"),
+						AssumeRolePolicyDocument: aws.String(url.QueryEscape(trustPolicy)),
+						PermissionsBoundary: &iam.AttachedPermissionsBoundary{
+							PermissionsBoundaryArn: aws.String("arn:aws:iam::123456789012:policy/my-boundary"),
+						},
+						Tags: iamTags(desiredTags(nil)),
+					},
+				},
+				listAttachedRolePoliciesOut: &iam.ListAttachedRolePoliciesOutput{
+					AttachedPolicies: []*iam.AttachedPolicy{
+						{
+							PolicyArn: aws.String("arn:aws:iam::123456789012:policy/my-policy"),
+						},
+					},
+				},
+			}),
+			opts: RoleOptions{
+				RemovePermissionsBoundary: true,
+			},
+			changes: []Change{
+				{
+					Action:   ChangeDelete,
+					Resource: ResourcePermissionsBoundary,
+					Name:     "my-role",
+					Before:   "arn:aws:iam::123456789012:policy/my-boundary",
+				},
+			},
+		},
+		// Role exists with a different trust policy, policy is attached.
+		{
+			mock: mutationErrors(&mockedIAMAPI{
+				getPolicyOut: &iam.GetPolicyOutput{
+					Policy: &iam.Policy{
+						Arn: aws.String("arn:aws:iam::123456789012:policy/my-policy"),
+					},
+				},
+				getRoleOut: &iam.GetRoleOutput{
+					Role: &iam.Role{
+						Path:                     aws.String("/"),
+						AssumeRolePolicyDocument: aws.String(url.QueryEscape(`{"Version": "2012-10-17"}`)),
+						Tags:                     iamTags(desiredTags(nil)),
+					},
+				},
+				listAttachedRolePoliciesOut: &iam.ListAttachedRolePoliciesOutput{
+					AttachedPolicies: []*iam.AttachedPolicy{
+						{
+							PolicyArn: aws.String("arn:aws:iam::123456789012:policy/my-policy"),
+						},
+					},
+				},
+			}),
+			changes: []Change{
+				{
+					Action:   ChangeUpdate,
+					Resource: ResourceTrustPolicy,
+					Name:     "my-role",
+					Before:   "{\n  \"Version\": \"2012-10-17\"\n}",
+					After:    formattedTrustPolicy,
+				},
+			},
+		},
+		// Role exists with stale tags, policy is attached.
+		{
+			mock: mutationErrors(&mockedIAMAPI{
+				getPolicyOut: &iam.GetPolicyOutput{
+					Policy: &iam.Policy{
+						Arn: aws.String("arn:aws:iam::123456789012:policy/my-policy"),
+					},
+				},
+				getRoleOut: &iam.GetRoleOutput{
+					Role: &iam.Role{
+						Path:                     aws.String("/"),
+						AssumeRolePolicyDocument: aws.String(url.QueryEscape(trustPolicy)),
+						Tags: []*iam.Tag{
+							{Key: aws.String(ClusterTagKey), Value: aws.String("dev")},
+							{Key: aws.String(NamespaceTagKey), Value: aws.String("default")},
+						},
+					},
+				},
+				listAttachedRolePoliciesOut: &iam.ListAttachedRolePoliciesOutput{
+					AttachedPolicies: []*iam.AttachedPolicy{
+						{
+							PolicyArn: aws.String("arn:aws:iam::123456789012:policy/my-policy"),
+						},
+					},
+				},
+			}),
+			opts: RoleOptions{
+				Tags: map[string]string{NamespaceTagKey: "web"},
+			},
+			changes: []Change{
+				{
+					Action:   ChangeUpdate,
+					Resource: ResourceRoleTags,
+					Name:     "my-role",
+					Before:   "cluster=dev, namespace=default",
+					After:    "namespace=web",
+				},
+			},
+		},
+		// Role exists with another description and max session duration,
+		// policy is attached.
+		{
+			mock: mutationErrors(&mockedIAMAPI{
+				getPolicyOut: &iam.GetPolicyOutput{
+					Policy: &iam.Policy{
+						Arn: aws.String("arn:aws:iam::123456789012:policy/my-policy"),
+					},
+				},
+				getRoleOut: &iam.GetRoleOutput{
+					Role: &iam.Role{
+						Path:                     aws.String("/"),
+						AssumeRolePolicyDocument: aws.String(url.QueryEscape(trustPolicy)),
+						Description:              aws.String("My old role"),
+						MaxSessionDuration:       aws.Int64(3600),
+						Tags:                     iamTags(desiredTags(nil)),
+					},
+				},
+				listAttachedRolePoliciesOut: &iam.ListAttachedRolePoliciesOutput{
+					AttachedPolicies: []*iam.AttachedPolicy{
+						{
+							PolicyArn: aws.String("arn:aws:iam::123456789012:policy/my-policy"),
+						},
+					},
+				},
+			}),
+			opts: RoleOptions{
+				Description:        "My role",
+				MaxSessionDuration: 4 * time.Hour,
+			},
+			changes: []Change{
+				{
+					Action:   ChangeUpdate,
+					Resource: ResourceRoleDescription,
+					Name:     "my-role",
+					Before:   "My old role",
+					After:    "My role",
+				},
+				{
+					Action:   ChangeUpdate,
+					Resource: ResourceMaxSessionDuration,
+					Name:     "my-role",
+					Before:   "1h0m0s",
+					After:    "4h0m0s",
+				},
+			},
+		},
+		// Another policy is attached, and kept.
+		{
+			mock: mutationErrors(&mockedIAMAPI{
+				getRoleOut: &iam.GetRoleOutput{
+					Role: &iam.Role{
+						Path:                     aws.String("/"),
+						AssumeRolePolicyDocument: aws.String(url.QueryEscape(trustPolicy)),
+						Tags:                     iamTags(desiredTags(nil)),
+					},
+				},
+				listAttachedRolePoliciesOut: &iam.ListAttachedRolePoliciesOutput{
+					AttachedPolicies: []*iam.AttachedPolicy{
+						{
+							PolicyArn:  aws.String("arn:aws:iam::123456789012:policy/my-old-policy"),
+							PolicyName: aws.String("my-old-policy"),
+						},
+						{
+							PolicyArn:  aws.String("arn:aws:iam::123456789012:policy/my-policy"),
+							PolicyName: aws.String("my-policy"),
+						},
+					},
+				},
+			}),
+			changes: nil,
+		},
+		// Another policy is attached, and detached with exclusive policies,
+		// another inline policy is kept.
+		{
+			mock: mutationErrors(&mockedIAMAPI{
+				getRoleOut: &iam.GetRoleOutput{
+					Role: &iam.Role{
+						Path:                     aws.String("/"),
+						AssumeRolePolicyDocument: aws.String(url.QueryEscape(trustPolicy)),
+						Tags:                     iamTags(desiredTags(nil)),
+					},
+				},
+				listAttachedRolePoliciesOut: &iam.ListAttachedRolePoliciesOutput{
+					AttachedPolicies: []*iam.AttachedPolicy{
+						{
+							PolicyArn:  aws.String("arn:aws:iam::123456789012:policy/my-old-policy"),
+							PolicyName: aws.String("my-old-policy"),
+						},
+						{
+							PolicyArn:  aws.String("arn:aws:iam::123456789012:policy/my-policy"),
+							PolicyName: aws.String("my-policy"),
+						},
+					},
+				},
+				listRolePoliciesOut: &iam.ListRolePoliciesOutput{
+					PolicyNames: aws.StringSlice([]string{"my-old-inline-policy"}),
+				},
+			}),
+			opts: RoleOptions{
+				ExclusivePolicies: true,
+			},
+			changes: []Change{
+				{
+					Action:   ChangeDetach,
+					Resource: ResourceRolePolicyAttachment,
+					Name:     "my-role",
+					Before:   "arn:aws:iam::123456789012:policy/my-old-policy",
+				},
+			},
+		},
+		// Another policy is attached, and detached with exclusive policies,
+		// another inline policy is deleted with exclusive inline policies.
+		{
+			mock: mutationErrors(&mockedIAMAPI{
+				getRoleOut: &iam.GetRoleOutput{
+					Role: &iam.Role{
+						Path:                     aws.String("/"),
+						AssumeRolePolicyDocument: aws.String(url.QueryEscape(trustPolicy)),
+						Tags:                     iamTags(desiredTags(nil)),
+					},
+				},
+				listAttachedRolePoliciesOut: &iam.ListAttachedRolePoliciesOutput{
+					AttachedPolicies: []*iam.AttachedPolicy{
+						{
+							PolicyArn:  aws.String("arn:aws:iam::123456789012:policy/my-old-policy"),
+							PolicyName: aws.String("my-old-policy"),
+						},
+						{
+							PolicyArn:  aws.String("arn:aws:iam::123456789012:policy/my-policy"),
+							PolicyName: aws.String("my-policy"),
+						},
+					},
+				},
+				listRolePoliciesOut: &iam.ListRolePoliciesOutput{
+					PolicyNames: aws.StringSlice([]string{"my-old-inline-policy"}),
+				},
+			}),
+			opts: RoleOptions{
+				ExclusivePolicies:       true,
+				ExclusiveInlinePolicies: true,
+			},
+			changes: []Change{
+				{
+					Action:   ChangeDetach,
+					Resource: ResourceRolePolicyAttachment,
+					Name:     "my-role",
+					Before:   "arn:aws:iam::123456789012:policy/my-old-policy",
+				},
+				{
+					Action:   ChangeDelete,
+					Resource: ResourceRolePolicy,
+					Name:     "my-role/my-old-inline-policy",
+				},
+			},
+		},
+	}
+	for _, tc := range testCases {
+		aw := awsWrapper{accountID: "123456789012", dryRun: true, iam: tc.mock}
+		result, err := aw.EnsureRole("my-role", []string{"my-policy"}, trustPolicy, tc.opts)
+		assert.NoError(t, err)
+		assert.Equal(t, tc.changes, result.Changes)
+	}
+}
@@ -0,0 +1,307 @@
+package awswrapper
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultPolicyVersion = "2012-10-17"
+)
+
+// PolicyDocument models the IAM JSON policy grammar. Every element of the
+// grammar has a field here, so parsing and serializing a document never drops
+// any part of it.
+type PolicyDocument struct {
+	Version   string     `json:",omitempty"`
+	Id        string     `json:",omitempty"`
+	Statement Statements `json:",omitempty"`
+}
+
+type PolicyStatement struct {
+	Sid          string        `json:",omitempty"`
+	Effect       string        `json:",omitempty"`
+	Principal    *Principal    `json:",omitempty"`
+	NotPrincipal *Principal    `json:",omitempty"`
+	Action       StringOrSlice `json:",omitempty"`
+	NotAction    StringOrSlice `json:",omitempty"`
+	Resource     StringOrSlice `json:",omitempty"`
+	NotResource  StringOrSlice `json:",omitempty"`
+	Condition    Condition     `json:",omitempty"`
+}
+
+// Statements accepts either a single statement object or a list of them.
+type Statements []PolicyStatement
+
+// StringOrSlice is a policy element that can be given either as a single
+// string or as a list of strings.
+type StringOrSlice []string
+
+// Principal is either the wildcard "*" or a map of principal types (AWS,
+// Service, Federated, CanonicalUser) to principal IDs.
+type Principal struct {
+	Wildcard bool
+	Values   map[string]StringOrSlice
+}
+
+// Condition maps condition operators to condition keys and their values, e.g.
+// {"StringEquals": {"aws:SourceVpc": ["vpc-1"]}}.
+type Condition map[string]map[string]ConditionValues
+
+// ConditionValues holds condition values as given in the document. Elements
+// are strings, bools or json.Numbers, so the original JSON types are kept.
+type ConditionValues []interface{}
+
+func ParsePolicyDocument(buf []byte) (*PolicyDocument, error) {
+	var doc PolicyDocument
+	if err := decodeStrict(buf, &doc); err != nil {
+		return nil, err
+	}
+	return &doc, nil
+}
+
+// decodeStrict is like json.Unmarshal, but fails on unknown fields instead of
+// silently ignoring them.
+func decodeStrict(buf []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(buf))
+	dec.DisallowUnknownFields()
+	dec.UseNumber()
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return errors.New("unexpected data after top-level value")
+	}
+	return nil
+}
+
+func isJSONArray(buf []byte) bool {
+	buf = bytes.TrimSpace(buf)
+	return len(buf) > 0 && buf[0] == '['
+}
+
+func (s *Statements) UnmarshalJSON(buf []byte) error {
+	if isJSONArray(buf) {
+		var statements []PolicyStatement
+		if err := decodeStrict(buf, &statements); err != nil {
+			return err
+		}
+		*s = statements
+		return nil
+	}
+	var statement PolicyStatement
+	if err := decodeStrict(buf, &statement); err != nil {
+		return err
+	}
+	*s = Statements{statement}
+	return nil
+}
+
+func (s *StringOrSlice) UnmarshalJSON(buf []byte) error {
+	if isJSONArray(buf) {
+		var values []string
+		if err := json.Unmarshal(buf, &values); err != nil {
+			return err
+		}
+		*s = values
+		return nil
+	}
+	var value string
+	if err := json.Unmarshal(buf, &value); err != nil {
+		return err
+	}
+	*s = StringOrSlice{value}
+	return nil
+}
+
+func (s StringOrSlice) MarshalJSON() ([]byte, error) {
+	if len(s) == 1 {
+		return json.Marshal(s[0])
+	}
+	return json.Marshal([]string(s))
+}
+
+func (p *Principal) UnmarshalJSON(buf []byte) error {
+	var wildcard string
+	if err := json.Unmarshal(buf, &wildcard); err == nil {
+		if wildcard != "*" {
+			return fmt.Errorf("invalid principal %q", wildcard)
+		}
+		*p = Principal{Wildcard: true}
+		return nil
+	}
+	var values map[string]StringOrSlice
+	if err := json.Unmarshal(buf, &values); err != nil {
+		return err
+	}
+	*p = Principal{Values: values}
+	return nil
+}
+
+func (p Principal) MarshalJSON() ([]byte, error) {
+	if p.Wildcard {
+		return json.Marshal("*")
+	}
+	return json.Marshal(p.Values)
+}
+
+func (c *ConditionValues) UnmarshalJSON(buf []byte) error {
+	var values []interface{}
+	if isJSONArray(buf) {
+		if err := decodeStrict(buf, &values); err != nil {
+			return err
+		}
+	} else {
+		var value interface{}
+		if err := decodeStrict(buf, &value); err != nil {
+			return err
+		}
+		values = []interface{}{value}
+	}
+	for _, v := range values {
+		switch v.(type) {
+		case string, bool, json.Number:
+		default:
+			return fmt.Errorf("invalid condition value %v", v)
+		}
+	}
+	*c = values
+	return nil
+}
+
+func (c ConditionValues) MarshalJSON() ([]byte, error) {
+	if len(c) == 1 {
+		return json.Marshal(c[0])
+	}
+	return json.Marshal([]interface{}(c))
+}
+
+// Canonical returns a normalized copy of the document. Elements that IAM
+// treats as sets are sorted and deduplicated, single values and lists are
+// unified, action names and condition keys are lowercased since they are case
+// insensitive, and a missing Version is set to the default.
+func (d *PolicyDocument) Canonical() *PolicyDocument {
+	canonical := &PolicyDocument{
+		Version: d.Version,
+		Id:      d.Id,
+	}
+	if canonical.Version == "" {
+		canonical.Version = defaultPolicyVersion
+	}
+	// Statements are sorted and deduplicated by their serialized canonical
+	// form.
+	byKey := make(map[string]PolicyStatement, len(d.Statement))
+	var keys []string
+	for _, s := range d.Statement {
+		statement := s.canonical()
+		buf, err := json.Marshal(statement)
+		if err != nil {
+			// Can't happen, statements only contain marshalable types.
+			panic(err)
+		}
+		key := string(buf)
+		if _, ok := byKey[key]; !ok {
+			keys = append(keys, key)
+		}
+		byKey[key] = statement
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		canonical.Statement = append(canonical.Statement, byKey[key])
+	}
+	return canonical
+}
+
+func (s PolicyStatement) canonical() PolicyStatement {
+	return PolicyStatement{
+		Sid:          s.Sid,
+		Effect:       s.Effect,
+		Principal:    s.Principal.canonical(),
+		NotPrincipal: s.NotPrincipal.canonical(),
+		Action:       canonicalSet(s.Action, strings.ToLower),
+		NotAction:    canonicalSet(s.NotAction, strings.ToLower),
+		Resource:     canonicalSet(s.Resource, nil),
+		NotResource:  canonicalSet(s.NotResource, nil),
+		Condition:    s.Condition.canonical(),
+	}
+}
+
+func (p *Principal) canonical() *Principal {
+	if p == nil {
+		return nil
+	}
+	if p.Wildcard {
+		return &Principal{Wildcard: true}
+	}
+	values := make(map[string]StringOrSlice, len(p.Values))
+	for principalType, ids := range p.Values {
+		values[principalType] = canonicalSet(ids, nil)
+	}
+	return &Principal{Values: values}
+}
+
+func (c Condition) canonical() Condition {
+	if len(c) == 0 {
+		return nil
+	}
+	canonical := make(Condition, len(c))
+	for operator, block := range c {
+		canonicalBlock := make(map[string]ConditionValues, len(block))
+		for key, values := range block {
+			strs := make([]string, 0, len(values))
+			for _, v := range values {
+				strs = append(strs, fmt.Sprint(v))
+			}
+			key = strings.ToLower(key)
+			for _, v := range canonicalBlock[key] {
+				strs = append(strs, v.(string))
+			}
+			var canonicalValues ConditionValues
+			for _, v := range canonicalSet(strs, nil) {
+				canonicalValues = append(canonicalValues, v)
+			}
+			canonicalBlock[key] = canonicalValues
+		}
+		canonical[operator] = canonicalBlock
+	}
+	return canonical
+}
+
+// canonicalSet returns the sorted, deduplicated values, each transformed by fn
+// if it is not nil.
+func canonicalSet(values []string, fn func(string) string) StringOrSlice {
+	if len(values) == 0 {
+		return nil
+	}
+	seen := make(map[string]bool, len(values))
+	var set StringOrSlice
+	for _, v := range values {
+		if fn != nil {
+			v = fn(v)
+		}
+		if seen[v] {
+			continue
+		}
+		seen[v] = true
+		set = append(set, v)
+	}
+	sort.Strings(set)
+	return set
+}
+
+// PoliciesEquivalent reports whether two policy documents have the same
+// canonical form.
+func PoliciesEquivalent(a, b *PolicyDocument) bool {
+	bufA, errA := json.Marshal(a.Canonical())
+	bufB, errB := json.Marshal(b.Canonical())
+	if errA != nil || errB != nil {
+		return false
+	}
+	return bytes.Equal(bufA, bufB)
+}
@@ -0,0 +1,151 @@
+package awswrapper
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePolicyDocumentRoundTrip(t *testing.T) {
+	testCases := []struct {
+		doc      string
+		expected string
+	}{
+		{
+			doc:      `{}`,
+			expected: `{}`,
+		},
+		{
+			doc:      `{"Version": "2012-10-17", "Statement": {"Effect": "Allow", "Action": "s3:GetObject", "Resource": "*"}}`,
+			expected: `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Action":"s3:GetObject","Resource":"*"}]}`,
+		},
+		{
+			doc: `{
+  "Version": "2012-10-17",
+  "Id": "my-policy",
+  "Statement": [
+    {
+      "Sid": "AllowGet",
+      "Effect": "Allow",
+      "Action": ["s3:GetObject", "s3:ListBucket"],
+      "Resource": ["arn:aws:s3:::my-bucket", "arn:aws:s3:::my-bucket/*"],
+      "Condition": {
+        "Bool": {"aws:SecureTransport": true},
+        "NumericLessThanEquals": {"s3:max-keys": 10},
+        "StringEquals": {"aws:SourceVpc": ["vpc-1", "vpc-2"]}
+      }
+    },
+    {
+      "Effect": "Deny",
+      "NotAction": "iam:*",
+      "NotResource": ["arn:aws:iam::123456789012:role/my-role"]
+    }
+  ]
+}`,
+			expected: `{"Version":"2012-10-17","Id":"my-policy","Statement":[{"Sid":"AllowGet","Effect":"Allow","Action":["s3:GetObject","s3:ListBucket"],"Resource":["arn:aws:s3:::my-bucket","arn:aws:s3:::my-bucket/*"],"Condition":{"Bool":{"aws:SecureTransport":true},"NumericLessThanEquals":{"s3:max-keys":10},"StringEquals":{"aws:SourceVpc":["vpc-1","vpc-2"]}}},{"Effect":"Deny","NotAction":"iam:*","NotResource":"arn:aws:iam::123456789012:role/my-role"}]}`,
+		},
+		{
+			doc:      `{"Statement": [{"Effect": "Allow", "Principal": "*", "Action": "sts:AssumeRole"}, {"Effect": "Deny", "NotPrincipal": {"AWS": ["arn:aws:iam::123456789012:root"]}, "Action": "sts:AssumeRole"}]}`,
+			expected: `{"Statement":[{"Effect":"Allow","Principal":"*","Action":"sts:AssumeRole"},{"Effect":"Deny","NotPrincipal":{"AWS":"arn:aws:iam::123456789012:root"},"Action":"sts:AssumeRole"}]}`,
+		},
+	}
+	for _, tc := range testCases {
+		doc, err := ParsePolicyDocument([]byte(tc.doc))
+		assert.NoError(t, err)
+		buf, err := json.Marshal(doc)
+		assert.NoError(t, err)
+		assert.JSONEq(t, tc.expected, string(buf))
+	}
+}
+
+func TestParsePolicyDocumentInvalid(t *testing.T) {
+	testCases := []string{
+		`invalid document`,
+		`{"Version": "2012-10-17"} {}`,
+		`{"Version": "2012-10-17", "Unknown": "field"}`,
+		`{"Statement": [{"Effect": "Allow", "Actions": "s3:GetObject"}]}`,
+		`{"Statement": [{"Effect": "Allow", "Action": 1}]}`,
+		`{"Statement": [{"Effect": "Allow", "Principal": "nobody"}]}`,
+		`{"Statement": [{"Effect": "Allow", "Condition": {"StringEquals": {"aws:SourceVpc": {"vpc": 1}}}}]}`,
+	}
+	for _, tc := range testCases {
+		_, err := ParsePolicyDocument([]byte(tc))
+		assert.Error(t, err, tc)
+	}
+}
+
+func TestPoliciesEquivalent(t *testing.T) {
+	testCases := []struct {
+		a          string
+		b          string
+		equivalent bool
+	}{
+		{
+			a:          `{}`,
+			b:          `{"Version": "2012-10-17"}`,
+			equivalent: true,
+		},
+		{
+			a:          `{"Version": "2008-10-17"}`,
+			b:          `{"Version": "2012-10-17"}`,
+			equivalent: false,
+		},
+		{
+			a:          `{"Statement": [{"Effect": "Allow", "Action": "s3:Get*", "Resource": "*"}]}`,
+			b:          `{"Statement": {"Effect": "Allow", "Action": ["S3:GET*"], "Resource": ["*"]}}`,
+			equivalent: true,
+		},
+		{
+			a:          `{"Statement": [{"Effect": "Allow", "Action": ["s3:GetObject", "s3:ListBucket", "s3:GetObject"], "Resource": ["arn:aws:s3:::b/*", "arn:aws:s3:::b"]}]}`,
+			b:          `{"Statement": [{"Effect": "Allow", "Action": ["s3:ListBucket", "s3:GetObject"], "Resource": ["arn:aws:s3:::b", "arn:aws:s3:::b/*"]}]}`,
+			equivalent: true,
+		},
+		{
+			a:          `{"Statement": [{"Effect": "Allow", "Action": "s3:*", "Resource": "*"}, {"Effect": "Deny", "Action": "s3:DeleteBucket", "Resource": "*"}]}`,
+			b:          `{"Statement": [{"Effect": "Deny", "Action": "s3:DeleteBucket", "Resource": "*"}, {"Effect": "Allow", "Action": "s3:*", "Resource": "*"}]}`,
+			equivalent: true,
+		},
+		{
+			a:          `{"Statement": [{"Effect": "Allow", "Action": "s3:*", "Resource": "arn:aws:s3:::Bucket"}]}`,
+			b:          `{"Statement": [{"Effect": "Allow", "Action": "s3:*", "Resource": "arn:aws:s3:::bucket"}]}`,
+			equivalent: false,
+		},
+		{
+			a:          `{"Statement": [{"Effect": "Allow", "Action": "s3:*", "Resource": "*"}]}`,
+			b:          `{"Statement": [{"Effect": "Deny", "Action": "s3:*", "Resource": "*"}]}`,
+			equivalent: false,
+		},
+		{
+			a:          `{"Statement": [{"Effect": "Allow", "Action": "s3:*", "Condition": {"Bool": {"aws:SecureTransport": true}, "StringEquals": {"aws:SourceVpc": ["vpc-2", "vpc-1"]}}}]}`,
+			b:          `{"Statement": [{"Effect": "Allow", "Action": "s3:*", "Condition": {"Bool": {"aws:securetransport": "true"}, "StringEquals": {"aws:SourceVpc": ["vpc-1", "vpc-2"]}}}]}`,
+			equivalent: true,
+		},
+		{
+			a:          `{"Statement": [{"Effect": "Allow", "Action": "s3:*", "Condition": {"StringEquals": {"aws:SourceVpc": "vpc-1"}}}]}`,
+			b:          `{"Statement": [{"Effect": "Allow", "Action": "s3:*", "Condition": {"StringLike": {"aws:SourceVpc": "vpc-1"}}}]}`,
+			equivalent: false,
+		},
+		{
+			a:          `{"Statement": [{"Effect": "Allow", "Principal": {"AWS": ["arn:aws:iam::2:root", "arn:aws:iam::1:root"]}, "Action": "sts:AssumeRole"}]}`,
+			b:          `{"Statement": [{"Effect": "Allow", "Principal": {"AWS": ["arn:aws:iam::1:root", "arn:aws:iam::2:root"]}, "Action": "sts:AssumeRole"}]}`,
+			equivalent: true,
+		},
+	}
+	for _, tc := range testCases {
+		a, err := ParsePolicyDocument([]byte(tc.a))
+		assert.NoError(t, err)
+		b, err := ParsePolicyDocument([]byte(tc.b))
+		assert.NoError(t, err)
+		assert.Equal(t, tc.equivalent, PoliciesEquivalent(a, b), "%s <-> %s", tc.a, tc.b)
+	}
+}
+
+func TestCanonicalKeepsOriginal(t *testing.T) {
+	doc, err := ParsePolicyDocument([]byte(`{"Statement": [{"Effect": "Allow", "Action": ["s3:PutObject", "S3:GetObject"]}]}`))
+	assert.NoError(t, err)
+	canonical := doc.Canonical()
+	assert.Equal(t, StringOrSlice{"s3:getobject", "s3:putobject"}, canonical.Statement[0].Action)
+	assert.Equal(t, StringOrSlice{"s3:PutObject", "S3:GetObject"}, doc.Statement[0].Action)
+	assert.Empty(t, doc.Version)
+}
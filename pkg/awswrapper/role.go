@@ -0,0 +1,84 @@
+package awswrapper
+
+import (
+	"log"
+	"regexp"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/pkg/errors"
+)
+
+const (
+	// defaultMaxSessionDuration is the maximum session duration IAM sets on
+	// roles created without one.
+	defaultMaxSessionDuration = time.Hour
+	maxMaxSessionDuration     = 12 * time.Hour
+	maxDescriptionLength      = 1000
+)
+
+var descriptionRegexp = regexp.MustCompile(`^[\t\n\r\x20-\x7e\x{a1}-\x{ff}]*$`)
+
+// validateRoleSettings checks the description and the maximum session
+// duration in opts. Empty values are valid, they leave the setting alone.
+func validateRoleSettings(opts RoleOptions) error {
+	if len([]rune(opts.Description)) > maxDescriptionLength || !descriptionRegexp.MatchString(opts.Description) {
+		return errors.Errorf("invalid description %q", opts.Description)
+	}
+	duration := opts.MaxSessionDuration
+	if duration != 0 && (duration < defaultMaxSessionDuration || duration > maxMaxSessionDuration || duration%time.Second != 0) {
+		return errors.Errorf("invalid max session duration %s, it must be whole seconds between %s and %s", duration, defaultMaxSessionDuration, maxMaxSessionDuration)
+	}
+	return nil
+}
+
+// ensureRoleSettings updates the description and the maximum session duration
+// of an existing role if they are set and differ.
+func (a *awsWrapper) ensureRoleSettings(roleName string, role *iam.Role, description string, maxSessionDuration time.Duration, result *EnsureResult) error {
+	input := &iam.UpdateRoleInput{
+		RoleName: aws.String(roleName),
+	}
+	var changes []Change
+	if current := aws.StringValue(role.Description); description != "" && current != description {
+		change := Change{
+			Action:   ChangeUpdate,
+			Resource: ResourceRoleDescription,
+			Name:     roleName,
+			Before:   current,
+			After:    description,
+		}
+		if current == "" {
+			change.Action = ChangeCreate
+		}
+		input.Description = aws.String(description)
+		changes = append(changes, change)
+	}
+	current := time.Duration(aws.Int64Value(role.MaxSessionDuration)) * time.Second
+	if current == 0 {
+		current = defaultMaxSessionDuration
+	}
+	if maxSessionDuration != 0 && current != maxSessionDuration {
+		input.MaxSessionDuration = aws.Int64(int64(maxSessionDuration / time.Second))
+		changes = append(changes, Change{
+			Action:   ChangeUpdate,
+			Resource: ResourceMaxSessionDuration,
+			Name:     roleName,
+			Before:   current.String(),
+			After:    maxSessionDuration.String(),
+		})
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+	if !a.dryRun {
+		if _, err := a.iam.UpdateRole(input); err != nil {
+			return errors.Wrapf(err, "update role %s", roleName)
+		}
+		log.Printf("Updated role %s description and max session duration", roleName)
+	}
+	for _, change := range changes {
+		result.add(change)
+	}
+	return nil
+}
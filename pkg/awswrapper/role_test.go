@@ -0,0 +1,129 @@
+package awswrapper
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateRoleSettings(t *testing.T) {
+	testCases := []struct {
+		opts RoleOptions
+		err  bool
+	}{
+		{
+			opts: RoleOptions{},
+		},
+		{
+			opts: RoleOptions{Description: "Role of my-app", MaxSessionDuration: 12 * time.Hour},
+		},
+		{
+			opts: RoleOptions{MaxSessionDuration: 90 * time.Minute},
+		},
+		{
+			opts: RoleOptions{MaxSessionDuration: 30 * time.Minute},
+			err:  true,
+		},
+		{
+			opts: RoleOptions{MaxSessionDuration: 13 * time.Hour},
+			err:  true,
+		},
+		{
+			opts: RoleOptions{MaxSessionDuration: time.Hour + time.Millisecond},
+			err:  true,
+		},
+		{
+			opts: RoleOptions{Description: strings.Repeat("a", 1001)},
+			err:  true,
+		},
+		{
+			opts: RoleOptions{Description: "emoji \U0001F600"},
+			err:  true,
+		},
+	}
+	for i, tc := range testCases {
+		err := validateRoleSettings(tc.opts)
+		if tc.err {
+			assert.Error(t, err, i)
+		} else {
+			assert.NoError(t, err, i)
+		}
+	}
+}
+
+func TestEnsureRoleSettings(t *testing.T) {
+	testCases := []struct {
+		mock        *mockedIAMAPI
+		role        *iam.Role
+		description string
+		duration    time.Duration
+		err         bool
+		changes     []Change
+	}{
+		{
+			mock:     mutationErrors(&mockedIAMAPI{}),
+			role:     &iam.Role{MaxSessionDuration: aws.Int64(3600)},
+			duration: time.Hour,
+		},
+		{
+			mock:        mutationErrors(&mockedIAMAPI{}),
+			role:        &iam.Role{Description: aws.String("My role"), MaxSessionDuration: aws.Int64(7200)},
+			description: "My role",
+			duration:    2 * time.Hour,
+		},
+		{
+			mock:        &mockedIAMAPI{updateRoleOut: &iam.UpdateRoleOutput{}},
+			role:        &iam.Role{MaxSessionDuration: aws.Int64(3600)},
+			description: "My role",
+			duration:    time.Hour,
+			changes: []Change{
+				{Action: ChangeCreate, Resource: ResourceRoleDescription, Name: "my-role", After: "My role"},
+			},
+		},
+		// Unset settings are left alone.
+		{
+			mock: mutationErrors(&mockedIAMAPI{}),
+			role: &iam.Role{Description: aws.String("My role"), MaxSessionDuration: aws.Int64(43200)},
+		},
+		{
+			mock:        &mockedIAMAPI{updateRoleOut: &iam.UpdateRoleOutput{}},
+			role:        &iam.Role{Description: aws.String("My old role"), MaxSessionDuration: aws.Int64(43200)},
+			description: "My role",
+			duration:    time.Hour,
+			changes: []Change{
+				{Action: ChangeUpdate, Resource: ResourceRoleDescription, Name: "my-role", Before: "My old role", After: "My role"},
+				{Action: ChangeUpdate, Resource: ResourceMaxSessionDuration, Name: "my-role", Before: "12h0m0s", After: "1h0m0s"},
+			},
+		},
+		{
+			mock:     &mockedIAMAPI{updateRoleOut: &iam.UpdateRoleOutput{}},
+			role:     &iam.Role{},
+			duration: 2 * time.Hour,
+			changes: []Change{
+				{Action: ChangeUpdate, Resource: ResourceMaxSessionDuration, Name: "my-role", Before: "1h0m0s", After: "2h0m0s"},
+			},
+		},
+		{
+			mock:     &mockedIAMAPI{updateRoleErr: fmt.Errorf("UpdateRole test error")},
+			role:     &iam.Role{MaxSessionDuration: aws.Int64(3600)},
+			duration: 8 * time.Hour,
+			err:      true,
+		},
+	}
+	for i, tc := range testCases {
+		aw := awsWrapper{accountID: "123456789012", iam: tc.mock}
+		result := &EnsureResult{}
+		err := aw.ensureRoleSettings("my-role", tc.role, tc.description, tc.duration, result)
+		if tc.err {
+			assert.Error(t, err, i)
+			continue
+		}
+		assert.NoError(t, err, i)
+		assert.Equal(t, tc.changes, result.Changes, i)
+	}
+}
@@ -0,0 +1,221 @@
+package awswrapper
+
+import (
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/pkg/errors"
+)
+
+const (
+	// ManagedByTagKey is set to ManagedByTagValue on roles and policies
+	// created by eks-iam-role. Only resources with this tag are deleted.
+	ManagedByTagKey      = "managed-by"
+	ManagedByTagValue    = "eks-iam-role"
+	ClusterTagKey        = "cluster"
+	NamespaceTagKey      = "namespace"
+	ServiceAccountTagKey = "service-account"
+	// maxTags is the IAM limit for tags per role or policy.
+	maxTags = 50
+)
+
+var (
+	// ownershipTagKeys are the tags set by eks-iam-role itself. They can't
+	// be set by users, and they are removed when no longer desired.
+	ownershipTagKeys = []string{ManagedByTagKey, ClusterTagKey, NamespaceTagKey, ServiceAccountTagKey}
+
+	tagKeyRegexp   = regexp.MustCompile(`^[\p{L}\p{Z}\p{N}_.:/=+\-@]{1,128}$`)
+	tagValueRegexp = regexp.MustCompile(`^[\p{L}\p{Z}\p{N}_.:/=+\-@]{0,256}$`)
+)
+
+// OwnershipTags returns tags recording the clusters and service accounts a
+// role is for. Multiple values are separated by spaces, since commas are not
+// allowed in tag values. So are the glob wildcards, so service account
+// patterns are left out.
+func OwnershipTags(clusters []string, serviceAccounts []ServiceAccount) map[string]string {
+	tags := make(map[string]string)
+	if len(clusters) > 0 {
+		tags[ClusterTagKey] = joinTagValues(clusters)
+	}
+	var namespaces, names []string
+	for _, sa := range serviceAccounts {
+		if sa.IsPattern() {
+			continue
+		}
+		namespaces = append(namespaces, sa.Namespace)
+		names = append(names, sa.Name)
+	}
+	if len(names) > 0 {
+		tags[NamespaceTagKey] = joinTagValues(namespaces)
+		tags[ServiceAccountTagKey] = joinTagValues(names)
+	}
+	return tags
+}
+
+// MergeTags returns the union of the tags. Later tags override earlier ones
+// with the same key.
+func MergeTags(tags ...map[string]string) map[string]string {
+	merged := make(map[string]string)
+	for _, t := range tags {
+		for key, value := range t {
+			merged[key] = value
+		}
+	}
+	return merged
+}
+
+// joinTagValues returns the sorted, deduplicated values separated by spaces,
+// truncated to the maximum length of tag values.
+func joinTagValues(values []string) string {
+	value := strings.Join(canonicalSet(values, nil), " ")
+	if len(value) > 256 {
+		value = value[:256]
+	}
+	return value
+}
+
+// ValidateTags checks that user supplied tags are valid IAM tags, and don't
+// set any of the tags set by eks-iam-role.
+func ValidateTags(tags map[string]string) error {
+	for key, value := range tags {
+		for _, ownershipKey := range ownershipTagKeys {
+			if key == ownershipKey {
+				return errors.Errorf("tag %s is reserved", key)
+			}
+		}
+		if !tagKeyRegexp.MatchString(key) || strings.HasPrefix(strings.ToLower(key), "aws:") {
+			return errors.Errorf("invalid tag key %q", key)
+		}
+		if !tagValueRegexp.MatchString(value) {
+			return errors.Errorf("invalid value %q for tag %s", value, key)
+		}
+	}
+	if len(tags)+len(ownershipTagKeys) > maxTags {
+		return errors.Errorf("at most %d tags can be set", maxTags-len(ownershipTagKeys))
+	}
+	return nil
+}
+
+// desiredTags returns the tags with the managed-by tag added. The managed-by
+// tag is only set when creating resources, see tagChanges.
+func desiredTags(tags map[string]string) map[string]string {
+	desired := make(map[string]string, len(tags)+1)
+	for key, value := range tags {
+		desired[key] = value
+	}
+	desired[ManagedByTagKey] = ManagedByTagValue
+	return desired
+}
+
+// iamTags returns the tags sorted by key.
+func iamTags(tags map[string]string) []*iam.Tag {
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	iamTags := make([]*iam.Tag, 0, len(keys))
+	for _, key := range keys {
+		iamTags = append(iamTags, &iam.Tag{
+			Key:   aws.String(key),
+			Value: aws.String(tags[key]),
+		})
+	}
+	return iamTags
+}
+
+func tagMap(tags []*iam.Tag) map[string]string {
+	m := make(map[string]string, len(tags))
+	for _, tag := range tags {
+		m[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+	}
+	return m
+}
+
+// isManaged reports whether the tags mark a resource as created by
+// eks-iam-role.
+func isManaged(tags []*iam.Tag) bool {
+	return tagMap(tags)[ManagedByTagKey] == ManagedByTagValue
+}
+
+// tagChanges returns the desired tags that are missing or have a different
+// value, and the ownership tags that are no longer desired. Other tags are
+// kept, since they might have been set outside of eks-iam-role. The managed-by
+// tag is never set, so existing resources adopted by eks-iam-role can't be
+// deleted by it.
+func tagChanges(current, desired map[string]string) (map[string]string, []string) {
+	set := make(map[string]string)
+	for key, value := range desired {
+		if key == ManagedByTagKey {
+			continue
+		}
+		if currentValue, ok := current[key]; !ok || currentValue != value {
+			set[key] = value
+		}
+	}
+	var remove []string
+	for _, key := range ownershipTagKeys {
+		if _, ok := current[key]; ok {
+			if _, ok := desired[key]; !ok {
+				remove = append(remove, key)
+			}
+		}
+	}
+	return set, remove
+}
+
+// formatTags returns the tags as a sorted, comma separated list of key=value
+// pairs.
+func formatTags(tags map[string]string) string {
+	pairs := make([]string, 0, len(tags))
+	for key, value := range tags {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ", ")
+}
+
+// reconcileTags sets the missing tags and removes the stale ownership tags of
+// a role or policy via tag and untag. The change is reported for resource,
+// ResourceRoleTags or ResourcePolicyTags.
+func (a *awsWrapper) reconcileTags(resource, name string, current []*iam.Tag, desired map[string]string, tag func([]*iam.Tag) error, untag func([]*string) error, result *EnsureResult) error {
+	currentTags := tagMap(current)
+	set, remove := tagChanges(currentTags, desired)
+	if len(set) == 0 && len(remove) == 0 {
+		return nil
+	}
+	if !a.dryRun {
+		if len(set) > 0 {
+			if err := tag(iamTags(set)); err != nil {
+				return errors.Wrapf(err, "tag %s", name)
+			}
+		}
+		if len(remove) > 0 {
+			if err := untag(aws.StringSlice(remove)); err != nil {
+				return errors.Wrapf(err, "untag %s", name)
+			}
+		}
+		log.Printf("Updated %s tags", name)
+	}
+	before := make(map[string]string)
+	for key := range set {
+		if value, ok := currentTags[key]; ok {
+			before[key] = value
+		}
+	}
+	for _, key := range remove {
+		before[key] = currentTags[key]
+	}
+	result.add(Change{
+		Action:   ChangeUpdate,
+		Resource: resource,
+		Name:     name,
+		Before:   formatTags(before),
+		After:    formatTags(set),
+	})
+	return nil
+}
@@ -0,0 +1,160 @@
+package awswrapper
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsManaged(t *testing.T) {
+	assert.True(t, isManaged(iamTags(desiredTags(nil))))
+	assert.False(t, isManaged(nil))
+	assert.False(t, isManaged([]*iam.Tag{
+		{Key: aws.String(ManagedByTagKey), Value: aws.String("terraform")},
+	}))
+}
+
+func TestOwnershipTags(t *testing.T) {
+	testCases := []struct {
+		clusters        []string
+		serviceAccounts []ServiceAccount
+		tags            map[string]string
+	}{
+		{
+			tags: map[string]string{},
+		},
+		{
+			clusters: []string{"prod", "dev", "prod"},
+			serviceAccounts: []ServiceAccount{
+				{Namespace: "default", Name: "my-app"},
+			},
+			tags: map[string]string{
+				ClusterTagKey:        "dev prod",
+				NamespaceTagKey:      "default",
+				ServiceAccountTagKey: "my-app",
+			},
+		},
+		// Patterns can't be tag values.
+		{
+			serviceAccounts: []ServiceAccount{
+				{Namespace: "preview-*", Name: "my-app"},
+				{Namespace: "web", Name: "frontend"},
+				{Namespace: "default", Name: "frontend"},
+			},
+			tags: map[string]string{
+				NamespaceTagKey:      "default web",
+				ServiceAccountTagKey: "frontend",
+			},
+		},
+		{
+			serviceAccounts: []ServiceAccount{
+				{Namespace: "preview-*", Name: "my-app"},
+			},
+			tags: map[string]string{},
+		},
+	}
+	for _, tc := range testCases {
+		assert.Equal(t, tc.tags, OwnershipTags(tc.clusters, tc.serviceAccounts))
+	}
+}
+
+func TestMergeTags(t *testing.T) {
+	assert.Equal(t, map[string]string{}, MergeTags())
+	assert.Equal(t, map[string]string{"a": "1", "b": "3", "c": "4"}, MergeTags(
+		map[string]string{"a": "1", "b": "2"},
+		nil,
+		map[string]string{"b": "3", "c": "4"},
+	))
+}
+
+func TestValidateTags(t *testing.T) {
+	testCases := []struct {
+		tags map[string]string
+		err  bool
+	}{
+		{
+			tags: nil,
+		},
+		{
+			tags: map[string]string{"team": "platform", "cost-center": "1234", "owner": "me@example.com"},
+		},
+		{
+			tags: map[string]string{"team": ""},
+		},
+		{
+			tags: map[string]string{ManagedByTagKey: "terraform"},
+			err:  true,
+		},
+		{
+			tags: map[string]string{ClusterTagKey: "prod"},
+			err:  true,
+		},
+		{
+			tags: map[string]string{"aws:team": "platform"},
+			err:  true,
+		},
+		{
+			tags: map[string]string{"": "platform"},
+			err:  true,
+		},
+		{
+			tags: map[string]string{"teams": "a,b"},
+			err:  true,
+		},
+		{
+			tags: map[string]string{"team": strings.Repeat("a", 257)},
+			err:  true,
+		},
+	}
+	for _, tc := range testCases {
+		err := ValidateTags(tc.tags)
+		if tc.err {
+			assert.Error(t, err, "%v", tc.tags)
+		} else {
+			assert.NoError(t, err, "%v", tc.tags)
+		}
+	}
+	tooMany := make(map[string]string)
+	for i := 0; i < maxTags; i++ {
+		tooMany[fmt.Sprintf("tag-%d", i)] = "value"
+	}
+	assert.Error(t, ValidateTags(tooMany))
+}
+
+func TestTagChanges(t *testing.T) {
+	testCases := []struct {
+		current map[string]string
+		desired map[string]string
+		set     map[string]string
+		remove  []string
+	}{
+		// Existing resources are not marked as managed by eks-iam-role.
+		{
+			current: map[string]string{},
+			desired: map[string]string{ManagedByTagKey: ManagedByTagValue, "team": "platform"},
+			set:     map[string]string{"team": "platform"},
+		},
+		{
+			current: map[string]string{ManagedByTagKey: ManagedByTagValue, "team": "platform"},
+			desired: map[string]string{ManagedByTagKey: ManagedByTagValue, "team": "platform"},
+			set:     map[string]string{},
+		},
+		// Tags set outside of eks-iam-role are kept, stale ownership tags
+		// are removed.
+		{
+			current: map[string]string{ManagedByTagKey: ManagedByTagValue, ClusterTagKey: "dev", NamespaceTagKey: "default", "team": "platform", "other": "value"},
+			desired: map[string]string{ManagedByTagKey: ManagedByTagValue, NamespaceTagKey: "web", "team": "infra"},
+			set:     map[string]string{NamespaceTagKey: "web", "team": "infra"},
+			remove:  []string{ClusterTagKey},
+		},
+	}
+	for _, tc := range testCases {
+		set, remove := tagChanges(tc.current, tc.desired)
+		assert.Equal(t, tc.set, set)
+		assert.Equal(t, tc.remove, remove)
+	}
+}
@@ -0,0 +1,235 @@
+package awswrapper
+
+import (
+	"encoding/json"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// defaultAudience is the audience of projected service account tokens
+	// used by the EKS pod identity webhook.
+	defaultAudience   = "sts.amazonaws.com"
+	webIdentityAction = "sts:AssumeRoleWithWebIdentity"
+)
+
+var (
+	namespacePatternRegexp = regexp.MustCompile(`^[a-z0-9*?]([-a-z0-9*?]*[a-z0-9*?])?$`)
+	namePatternRegexp      = regexp.MustCompile(`^[a-z0-9*?]([-.a-z0-9*?]*[a-z0-9*?])?$`)
+)
+
+// ServiceAccount identifies a Kubernetes service account.
+type ServiceAccount struct {
+	Namespace string
+	Name      string
+}
+
+func (s ServiceAccount) String() string {
+	return s.Namespace + ":" + s.Name
+}
+
+// IsPattern reports whether the namespace or the name is a glob pattern.
+func (s ServiceAccount) IsPattern() bool {
+	return isGlobPattern(s.Namespace) || isGlobPattern(s.Name)
+}
+
+// subject returns the subject of tokens issued for the service account.
+func (s ServiceAccount) subject() string {
+	return "system:serviceaccount:" + s.Namespace + ":" + s.Name
+}
+
+// TrustOptions configures which service account tokens can assume a role.
+type TrustOptions struct {
+	// ServiceAccounts may use the glob wildcards * and ? in namespaces and
+	// names, e.g. {Namespace: "preview-*", Name: "my-app"}.
+	ServiceAccounts []ServiceAccount
+	// Audiences are allowed in addition to the default sts.amazonaws.com.
+	Audiences []string
+	// AllowBroadPatterns allows namespace patterns matching any namespace.
+	AllowBroadPatterns bool
+}
+
+// subjects returns the sorted and deduplicated token subjects of the service
+// accounts, so the generated trust policy does not depend on their order,
+// and the condition operator needed to match them.
+func (o TrustOptions) subjects() ([]string, string, error) {
+	if len(o.ServiceAccounts) == 0 {
+		return nil, "", errors.New("no service accounts")
+	}
+	operator := "StringEquals"
+	seen := make(map[string]bool)
+	var subjects []string
+	for _, sa := range o.ServiceAccounts {
+		if err := sa.validate(o.AllowBroadPatterns); err != nil {
+			return nil, "", err
+		}
+		if sa.IsPattern() {
+			// All subjects are in a single condition, and StringLike matches
+			// values without wildcards exactly.
+			operator = "StringLike"
+		}
+		subject := sa.subject()
+		if seen[subject] {
+			continue
+		}
+		seen[subject] = true
+		subjects = append(subjects, subject)
+	}
+	sort.Strings(subjects)
+	return subjects, operator, nil
+}
+
+func isGlobPattern(s string) bool {
+	return strings.ContainsAny(s, "*?")
+}
+
+// validate checks the namespace and name of the service account, which can be
+// glob patterns. A namespace pattern matching any namespace is refused unless
+// allowBroad is set, since it would let every namespace, including the ones
+// of other teams, assume the role.
+func (s ServiceAccount) validate(allowBroad bool) error {
+	if len(s.Namespace) > 63 || !namespacePatternRegexp.MatchString(s.Namespace) {
+		return errors.Errorf("invalid namespace %q for service account %q", s.Namespace, s)
+	}
+	if len(s.Name) > 253 || !namePatternRegexp.MatchString(s.Name) {
+		return errors.Errorf("invalid name %q for service account %q", s.Name, s)
+	}
+	if !allowBroad && strings.Trim(s.Namespace, "*?") == "" {
+		return errors.Errorf("service account pattern %q matches any namespace", s)
+	}
+	return nil
+}
+
+// TrustPrincipal is a principal allowed to assume a role, e.g. Type
+// "Federated" with an OIDC provider ARN as ID.
+type TrustPrincipal struct {
+	Type string
+	ID   string
+}
+
+// TrustCondition requires the value of a condition key to match one of the
+// values using the operator, e.g. StringEquals.
+type TrustCondition struct {
+	Operator string
+	Key      string
+	Values   []string
+}
+
+// TrustStatement allows the principals to assume a role via the actions if
+// all the conditions are met.
+type TrustStatement struct {
+	Principals []TrustPrincipal
+	Actions    []string
+	Conditions []TrustCondition
+}
+
+// TrustPolicyBuilder builds role trust policy documents from typed
+// statements.
+type TrustPolicyBuilder struct {
+	statements []TrustStatement
+}
+
+func NewTrustPolicyBuilder() *TrustPolicyBuilder {
+	return &TrustPolicyBuilder{}
+}
+
+func (b *TrustPolicyBuilder) Add(statement TrustStatement) *TrustPolicyBuilder {
+	b.statements = append(b.statements, statement)
+	return b
+}
+
+// AddWebIdentity allows tokens of the OIDC provider to assume the role if all
+// the conditions are met.
+func (b *TrustPolicyBuilder) AddWebIdentity(providerARN string, conditions ...TrustCondition) *TrustPolicyBuilder {
+	return b.Add(TrustStatement{
+		Principals: []TrustPrincipal{
+			{
+				Type: "Federated",
+				ID:   providerARN,
+			},
+		},
+		Actions:    []string{webIdentityAction},
+		Conditions: conditions,
+	})
+}
+
+func (b *TrustPolicyBuilder) Document() (*PolicyDocument, error) {
+	doc := &PolicyDocument{
+		Version: defaultPolicyVersion,
+	}
+	for i, s := range b.statements {
+		if len(s.Principals) == 0 {
+			return nil, errors.Errorf("trust statement %d has no principals", i)
+		}
+		if len(s.Actions) == 0 {
+			return nil, errors.Errorf("trust statement %d has no actions", i)
+		}
+		statement := PolicyStatement{
+			Effect: "Allow",
+			Principal: &Principal{
+				Values: make(map[string]StringOrSlice),
+			},
+			Action: StringOrSlice(s.Actions),
+		}
+		for _, p := range s.Principals {
+			if p.Type == "" || p.ID == "" {
+				return nil, errors.Errorf("trust statement %d has an invalid principal %+v", i, p)
+			}
+			statement.Principal.Values[p.Type] = append(statement.Principal.Values[p.Type], p.ID)
+		}
+		for _, c := range s.Conditions {
+			if c.Operator == "" || c.Key == "" || len(c.Values) == 0 {
+				return nil, errors.Errorf("trust statement %d has an invalid condition %+v", i, c)
+			}
+			if statement.Condition == nil {
+				statement.Condition = make(Condition)
+			}
+			block := statement.Condition[c.Operator]
+			if block == nil {
+				block = make(map[string]ConditionValues)
+				statement.Condition[c.Operator] = block
+			}
+			if _, ok := block[c.Key]; ok {
+				// Merging the values would turn the two conditions into one
+				// that matches either of them.
+				return nil, errors.Errorf("trust statement %d has multiple %s conditions for %s", i, c.Operator, c.Key)
+			}
+			var values ConditionValues
+			for _, v := range c.Values {
+				values = append(values, v)
+			}
+			block[c.Key] = values
+		}
+		doc.Statement = append(doc.Statement, statement)
+	}
+	return doc, nil
+}
+
+// Build returns the trust policy document as JSON.
+func (b *TrustPolicyBuilder) Build() (string, error) {
+	doc, err := b.Document()
+	if err != nil {
+		return "", err
+	}
+	buf, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+func trustAudiences(extra []string) []string {
+	audiences := []string{defaultAudience}
+	seen := map[string]bool{defaultAudience: true}
+	for _, audience := range extra {
+		if audience == "" || seen[audience] {
+			continue
+		}
+		seen[audience] = true
+		audiences = append(audiences, audience)
+	}
+	return audiences
+}
@@ -0,0 +1,231 @@
+package awswrapper
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrustPolicyBuilder(t *testing.T) {
+	policy, err := NewTrustPolicyBuilder().
+		AddWebIdentity(
+			"arn:aws:iam::123456789012:oidc-provider/my-issuer",
+			TrustCondition{
+				Operator: "StringEquals",
+				Key:      "my-issuer:sub",
+				Values:   []string{"system:serviceaccount:my-namespace:my-service-account"},
+			},
+			TrustCondition{
+				Operator: "StringEquals",
+				Key:      "my-issuer:aud",
+				Values:   []string{"sts.amazonaws.com", "my-audience"},
+			},
+		).
+		Add(TrustStatement{
+			Principals: []TrustPrincipal{
+				{Type: "Service", ID: "ec2.amazonaws.com"},
+				{Type: "AWS", ID: "arn:aws:iam::123456789012:root"},
+			},
+			Actions: []string{"sts:AssumeRole", "sts:TagSession"},
+		}).
+		Build()
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{
+  "Version": "2012-10-17",
+  "Statement": [
+    {
+      "Effect": "Allow",
+      "Principal": {"Federated": "arn:aws:iam::123456789012:oidc-provider/my-issuer"},
+      "Action": "sts:AssumeRoleWithWebIdentity",
+      "Condition": {
+        "StringEquals": {
+          "my-issuer:sub": "system:serviceaccount:my-namespace:my-service-account",
+          "my-issuer:aud": ["sts.amazonaws.com", "my-audience"]
+        }
+      }
+    },
+    {
+      "Effect": "Allow",
+      "Principal": {"Service": "ec2.amazonaws.com", "AWS": "arn:aws:iam::123456789012:root"},
+      "Action": ["sts:AssumeRole", "sts:TagSession"]
+    }
+  ]
+}`, policy)
+}
+
+func TestTrustPolicyBuilderEscapesValues(t *testing.T) {
+	policy, err := NewTrustPolicyBuilder().
+		AddWebIdentity(
+			"arn:aws:iam::123456789012:oidc-provider/my-issuer",
+			TrustCondition{
+				Operator: "StringEquals",
+				Key:      "my-issuer:sub",
+				Values:   []string{`system:serviceaccount:"quoted":sa`},
+			},
+		).
+		Build()
+	assert.NoError(t, err)
+	doc, err := ParsePolicyDocument([]byte(policy))
+	assert.NoError(t, err)
+	assert.Equal(t, ConditionValues{`system:serviceaccount:"quoted":sa`}, doc.Statement[0].Condition["StringEquals"]["my-issuer:sub"])
+}
+
+func TestTrustPolicyBuilderInvalid(t *testing.T) {
+	testCases := []TrustStatement{
+		{
+			Actions: []string{"sts:AssumeRole"},
+		},
+		{
+			Principals: []TrustPrincipal{{Type: "Service", ID: "ec2.amazonaws.com"}},
+		},
+		{
+			Principals: []TrustPrincipal{{Type: "Service"}},
+			Actions:    []string{"sts:AssumeRole"},
+		},
+		{
+			Principals: []TrustPrincipal{{Type: "Service", ID: "ec2.amazonaws.com"}},
+			Actions:    []string{"sts:AssumeRole"},
+			Conditions: []TrustCondition{{Operator: "StringEquals", Key: "aws:SourceAccount"}},
+		},
+		{
+			Principals: []TrustPrincipal{{Type: "Service", ID: "ec2.amazonaws.com"}},
+			Actions:    []string{"sts:AssumeRole"},
+			Conditions: []TrustCondition{
+				{Operator: "StringEquals", Key: "aws:SourceAccount", Values: []string{"1"}},
+				{Operator: "StringEquals", Key: "aws:SourceAccount", Values: []string{"2"}},
+			},
+		},
+	}
+	for _, tc := range testCases {
+		_, err := NewTrustPolicyBuilder().Add(tc).Build()
+		assert.Error(t, err, "%+v", tc)
+	}
+}
+
+func TestTrustOptionsSubjects(t *testing.T) {
+	testCases := []struct {
+		opts     TrustOptions
+		subjects []string
+		operator string
+		err      bool
+	}{
+		{
+			opts: TrustOptions{
+				ServiceAccounts: []ServiceAccount{
+					{Namespace: "my-namespace", Name: "my-service-account"},
+				},
+			},
+			subjects: []string{"system:serviceaccount:my-namespace:my-service-account"},
+			operator: "StringEquals",
+		},
+		{
+			opts: TrustOptions{
+				ServiceAccounts: []ServiceAccount{
+					{Namespace: "production", Name: "my-service-account"},
+					{Namespace: "preview-*", Name: "my-service-account"},
+				},
+			},
+			subjects: []string{
+				"system:serviceaccount:preview-*:my-service-account",
+				"system:serviceaccount:production:my-service-account",
+			},
+			operator: "StringLike",
+		},
+		{
+			opts: TrustOptions{
+				ServiceAccounts: []ServiceAccount{
+					{Namespace: "my-namespace", Name: "worker-?"},
+				},
+			},
+			subjects: []string{"system:serviceaccount:my-namespace:worker-?"},
+			operator: "StringLike",
+		},
+		{
+			opts: TrustOptions{
+				ServiceAccounts: []ServiceAccount{
+					{Namespace: "my-namespace", Name: "*"},
+				},
+			},
+			subjects: []string{"system:serviceaccount:my-namespace:*"},
+			operator: "StringLike",
+		},
+		{
+			opts: TrustOptions{
+				ServiceAccounts: []ServiceAccount{
+					{Namespace: "*", Name: "*"},
+				},
+			},
+			err: true,
+		},
+		{
+			opts: TrustOptions{
+				ServiceAccounts: []ServiceAccount{
+					{Namespace: "*", Name: "my-service-account"},
+				},
+			},
+			err: true,
+		},
+		{
+			opts: TrustOptions{
+				ServiceAccounts: []ServiceAccount{
+					{Namespace: "*", Name: "*"},
+				},
+				AllowBroadPatterns: true,
+			},
+			subjects: []string{"system:serviceaccount:*:*"},
+			operator: "StringLike",
+		},
+		{
+			opts: TrustOptions{},
+			err:  true,
+		},
+		{
+			opts: TrustOptions{
+				ServiceAccounts: []ServiceAccount{
+					{Namespace: "My_Namespace", Name: "my-service-account"},
+				},
+			},
+			err: true,
+		},
+		{
+			opts: TrustOptions{
+				ServiceAccounts: []ServiceAccount{
+					{Namespace: "my-namespace", Name: "my:service-account"},
+				},
+			},
+			err: true,
+		},
+		{
+			opts: TrustOptions{
+				ServiceAccounts: []ServiceAccount{
+					{Namespace: "my-namespace"},
+				},
+			},
+			err: true,
+		},
+	}
+	for _, tc := range testCases {
+		subjects, operator, err := tc.opts.subjects()
+		if tc.err {
+			assert.Error(t, err, "%+v", tc.opts)
+		} else {
+			assert.NoError(t, err, "%+v", tc.opts)
+			assert.Equal(t, tc.subjects, subjects)
+			assert.Equal(t, tc.operator, operator)
+		}
+	}
+}
+
+func TestServiceAccountIsPattern(t *testing.T) {
+	testCases := []struct {
+		sa       ServiceAccount
+		expected bool
+	}{
+		{sa: ServiceAccount{Namespace: "my-namespace", Name: "my-app"}, expected: false},
+		{sa: ServiceAccount{Namespace: "preview-*", Name: "my-app"}, expected: true},
+		{sa: ServiceAccount{Namespace: "my-namespace", Name: "my-app-?"}, expected: true},
+	}
+	for _, tc := range testCases {
+		assert.Equal(t, tc.expected, tc.sa.IsPattern(), tc.sa.String())
+	}
+}
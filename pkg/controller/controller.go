@@ -0,0 +1,49 @@
+// Package controller reconciles Kubernetes resources into IAM roles and
+// policies.
+package controller
+
+import (
+	"github.com/ldx/eks_iam_role/pkg/awswrapper"
+	"github.com/pkg/errors"
+)
+
+const (
+	// maxRoleNameLength is the IAM limit for role names.
+	maxRoleNameLength = 64
+)
+
+// roleName returns the name of the role and policy for name in namespace.
+// Namespace and name are joined by an underscore, which is valid in neither,
+// so roles of different namespaces never get the same name.
+func roleName(prefix, namespace, name string) (string, error) {
+	roleName := prefix + namespace + "_" + name
+	if len(roleName) > maxRoleNameLength {
+		return "", errors.Errorf("role name %s is longer than %d characters", roleName, maxRoleNameLength)
+	}
+	return roleName, nil
+}
+
+// ensureRole ensures a policy with the document and a role with the same name,
+// trusted by the issuers. Both are tagged with the ownership tags of the
+// clusters and service accounts, and the tags in roleOpts. The policy is
+// created under roleOpts.PolicyPath.
+func ensureRole(aw awswrapper.AWSWrapper, roleName string, policyDocument []byte, issuers []string, trustOpts awswrapper.TrustOptions, clusters []string, roleOpts awswrapper.RoleOptions) (*awswrapper.EnsureResult, *awswrapper.EnsureResult, error) {
+	tags := awswrapper.MergeTags(awswrapper.OwnershipTags(clusters, trustOpts.ServiceAccounts), roleOpts.Tags)
+	roleOpts.Tags = tags
+	trustPolicy, err := aw.TrustPolicyFromOIDCIssuers(issuers, trustOpts)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "getting trust policy")
+	}
+	policyResult, err := aw.EnsurePolicy(roleName, policyDocument, awswrapper.PolicyOptions{
+		Path: roleOpts.PolicyPath,
+		Tags: tags,
+	})
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "ensuring policy %s", roleName)
+	}
+	roleResult, err := aw.EnsureRole(roleName, []string{roleName}, trustPolicy, roleOpts)
+	if err != nil {
+		return policyResult, nil, errors.Wrapf(err, "ensuring role %s", roleName)
+	}
+	return policyResult, roleResult, nil
+}
@@ -0,0 +1,80 @@
+package controller
+
+import (
+	"testing"
+
+	"github.com/ldx/eks_iam_role/pkg/awswrapper"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRoleName(t *testing.T) {
+	testCases := []struct {
+		prefix    string
+		namespace string
+		name      string
+		expected  string
+		err       bool
+	}{
+		{
+			prefix:    "eks-",
+			namespace: "my-namespace",
+			name:      "my-app",
+			expected:  "eks-my-namespace_my-app",
+		},
+		{
+			namespace: "my-namespace",
+			name:      "my-app",
+			expected:  "my-namespace_my-app",
+		},
+		{
+			prefix:    "eks-",
+			namespace: "my-namespace",
+			name:      "my-app-with-a-very-long-name-that-does-not-fit-into-a-role-name",
+			err:       true,
+		},
+	}
+	for _, tc := range testCases {
+		roleName, err := roleName(tc.prefix, tc.namespace, tc.name)
+		if tc.err {
+			assert.Error(t, err)
+			continue
+		}
+		assert.NoError(t, err)
+		assert.Equal(t, tc.expected, roleName)
+	}
+}
+
+func TestEnsureRole(t *testing.T) {
+	aw := &mockedAWSWrapper{
+		policies:      make(map[string]string),
+		roles:         make(map[string]string),
+		roleTags:      make(map[string]map[string]string),
+		trustPolicies: make(map[string]string),
+	}
+	trustOpts := awswrapper.TrustOptions{
+		ServiceAccounts: []awswrapper.ServiceAccount{
+			{Namespace: "my-namespace", Name: "my-app"},
+		},
+	}
+	policyResult, roleResult, err := ensureRole(aw, "my-role", []byte(`{}`), []string{"my-issuer"}, trustOpts, []string{"my-cluster"}, awswrapper.RoleOptions{
+		Tags: map[string]string{"team": "platform"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "arn:aws:iam::123456789012:policy/my-role", policyResult.ARN)
+	assert.Equal(t, "arn:aws:iam::123456789012:role/my-role", roleResult.ARN)
+	assert.Equal(t, map[string]string{"my-role": `{}`}, aw.policies)
+	assert.Equal(t, map[string]string{"my-role": "my-role"}, aw.roles)
+	assert.JSONEq(t, `{"issuers": ["my-issuer"], "serviceAccounts": [{"Namespace": "my-namespace", "Name": "my-app"}]}`, aw.trustPolicies["my-role"])
+	assert.Equal(t, map[string]string{
+		awswrapper.ClusterTagKey:        "my-cluster",
+		awswrapper.NamespaceTagKey:      "my-namespace",
+		awswrapper.ServiceAccountTagKey: "my-app",
+		"team":                          "platform",
+	}, aw.roleTags["my-role"])
+
+	aw.ensurePolicyErr = errors.New("access denied")
+	_, _, err = ensureRole(aw, "other-role", []byte(`{}`), []string{"my-issuer"}, trustOpts, nil, awswrapper.RoleOptions{})
+	assert.Error(t, err)
+	assert.NotContains(t, aw.roles, "other-role")
+}
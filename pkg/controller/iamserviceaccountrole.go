@@ -0,0 +1,201 @@
+package controller
+
+import (
+	"context"
+	"time"
+
+	"github.com/ldx/eks_iam_role/pkg/apis/v1alpha1"
+	"github.com/ldx/eks_iam_role/pkg/awswrapper"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// IAMServiceAccountRoleReconciler ensures the role and policy of every
+// IAMServiceAccountRole, and reports them in its status.
+type IAMServiceAccountRoleReconciler struct {
+	client.Client
+	AWS      awswrapper.AWSWrapper
+	Recorder record.EventRecorder
+	// OIDCIssuers are trusted by roles that don't set clusters. For the
+	// other fields see ServiceAccountReconciler.
+	OIDCIssuers               []string
+	Clusters                  []string
+	Audiences                 []string
+	Tags                      map[string]string
+	PermissionsBoundary       string
+	RemovePermissionsBoundary bool
+	RolePath                  string
+	PolicyPath                string
+	RoleDescription           string
+	MaxSessionDuration        time.Duration
+	// RolePrefix is prepended to the names of roles and policies, see
+	// ServiceAccountReconciler.
+	RolePrefix   string
+	ResyncPeriod time.Duration
+}
+
+// RoleName returns the name of the role and policy of an
+// IAMServiceAccountRole. Don't manage the same role with both an
+// IAMServiceAccountRole and an annotated service account, they would get the
+// same name if spec.roleName is the name of the service account.
+func (r *IAMServiceAccountRoleReconciler) RoleName(role *v1alpha1.IAMServiceAccountRole) (string, error) {
+	name := role.Spec.RoleName
+	if name == "" {
+		name = role.Name
+	}
+	return roleName(r.RolePrefix, role.Namespace, name)
+}
+
+func (r *IAMServiceAccountRoleReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := ctrllog.FromContext(ctx)
+	var role v1alpha1.IAMServiceAccountRole
+	if err := r.Get(ctx, req.NamespacedName, &role); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	status := role.Status.DeepCopy()
+	status.ObservedGeneration = role.Generation
+	policyResult, roleResult, err := r.ensure(ctx, &role)
+	condition := metav1.Condition{
+		Type:               v1alpha1.ConditionReady,
+		Status:             metav1.ConditionTrue,
+		Reason:             "Reconciled",
+		Message:            "Role and policy are up to date",
+		ObservedGeneration: role.Generation,
+	}
+	if err != nil {
+		log.Error(err, "Reconciling IAMServiceAccountRole")
+		r.Recorder.Event(&role, corev1.EventTypeWarning, "ReconcileFailed", err.Error())
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "ReconcileFailed"
+		condition.Message = err.Error()
+	} else {
+		if policyResult.Changed() || roleResult.Changed() {
+			r.Recorder.Eventf(&role, corev1.EventTypeNormal, "Reconciled", "Role %s updated", roleResult.ARN)
+		}
+		status.RoleARN = roleResult.ARN
+		status.PolicyARN = policyResult.ARN
+		status.PolicyVersion = policyResult.VersionID
+		now := metav1.Now()
+		status.LastSyncTime = &now
+	}
+	meta.SetStatusCondition(&status.Conditions, condition)
+	if !equality.Semantic.DeepEqual(status, &role.Status) {
+		role.Status = *status
+		if statusErr := r.Status().Update(ctx, &role); statusErr != nil {
+			return ctrl.Result{}, errors.Wrapf(statusErr, "updating status of %s/%s", role.Namespace, role.Name)
+		}
+	}
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{RequeueAfter: r.ResyncPeriod}, nil
+}
+
+func (r *IAMServiceAccountRoleReconciler) ensure(ctx context.Context, role *v1alpha1.IAMServiceAccountRole) (*awswrapper.EnsureResult, *awswrapper.EnsureResult, error) {
+	roleName, err := r.RoleName(role)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(role.Spec.ServiceAccounts) == 0 {
+		return nil, nil, errors.New("no service accounts")
+	}
+	policyDocument, err := r.policyDocument(ctx, role)
+	if err != nil {
+		return nil, nil, err
+	}
+	issuers, clusters := r.OIDCIssuers, r.Clusters
+	if len(role.Spec.Clusters) > 0 {
+		clusters = role.Spec.Clusters
+		issuers, err = r.AWS.OIDCIssuersFromClusters(role.Spec.Clusters)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "getting OIDC issuers")
+		}
+	}
+	trustOpts := awswrapper.TrustOptions{
+		Audiences: r.Audiences,
+	}
+	for _, name := range role.Spec.ServiceAccounts {
+		trustOpts.ServiceAccounts = append(trustOpts.ServiceAccounts, awswrapper.ServiceAccount{
+			Namespace: role.Namespace,
+			Name:      name,
+		})
+	}
+	return ensureRole(r.AWS, roleName, policyDocument, issuers, trustOpts, clusters, awswrapper.RoleOptions{
+		Path:                      r.RolePath,
+		PolicyPath:                r.PolicyPath,
+		Tags:                      r.Tags,
+		PermissionsBoundary:       r.PermissionsBoundary,
+		RemovePermissionsBoundary: r.RemovePermissionsBoundary,
+		Description:               r.RoleDescription,
+		MaxSessionDuration:        r.MaxSessionDuration,
+	})
+}
+
+// policyDocument returns the policy document given in the spec or in the
+// referenced ConfigMap.
+func (r *IAMServiceAccountRoleReconciler) policyDocument(ctx context.Context, role *v1alpha1.IAMServiceAccountRole) ([]byte, error) {
+	ref := role.Spec.PolicyConfigMapRef
+	if role.Spec.PolicyDocument != "" {
+		if ref != nil {
+			return nil, errors.New("both policyDocument and policyConfigMapRef are set")
+		}
+		return []byte(role.Spec.PolicyDocument), nil
+	}
+	if ref == nil {
+		return nil, errors.New("either policyDocument or policyConfigMapRef needs to be set")
+	}
+	var cm corev1.ConfigMap
+	key := types.NamespacedName{Namespace: role.Namespace, Name: ref.Name}
+	if err := r.Get(ctx, key, &cm); err != nil {
+		return nil, errors.Wrapf(err, "getting policy config map %s", ref.Name)
+	}
+	policyDocument, ok := cm.Data[ref.Key]
+	if !ok {
+		return nil, errors.Errorf("policy config map %s has no %s", ref.Name, ref.Key)
+	}
+	return []byte(policyDocument), nil
+}
+
+// rolesForConfigMap returns the IAMServiceAccountRoles referencing the
+// ConfigMap, so they are reconciled when their policy document changes.
+func (r *IAMServiceAccountRoleReconciler) rolesForConfigMap(obj client.Object) []reconcile.Request {
+	var roles v1alpha1.IAMServiceAccountRoleList
+	if err := r.List(context.Background(), &roles, client.InNamespace(obj.GetNamespace())); err != nil {
+		return nil
+	}
+	var requests []reconcile.Request
+	for _, role := range roles.Items {
+		ref := role.Spec.PolicyConfigMapRef
+		if ref != nil && ref.Name == obj.GetName() {
+			requests = append(requests, reconcile.Request{
+				NamespacedName: types.NamespacedName{
+					Namespace: role.Namespace,
+					Name:      role.Name,
+				},
+			})
+		}
+	}
+	return requests
+}
+
+func (r *IAMServiceAccountRoleReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	// Status updates don't change the generation, so they don't trigger
+	// reconciles.
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&v1alpha1.IAMServiceAccountRole{}, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Watches(&source.Kind{Type: &corev1.ConfigMap{}}, handler.EnqueueRequestsFromMapFunc(r.rolesForConfigMap)).
+		Complete(r)
+}
@@ -0,0 +1,192 @@
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ldx/eks_iam_role/pkg/apis/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func newScheme(t *testing.T) *runtime.Scheme {
+	scheme := runtime.NewScheme()
+	assert.NoError(t, clientgoscheme.AddToScheme(scheme))
+	assert.NoError(t, v1alpha1.AddToScheme(scheme))
+	return scheme
+}
+
+func newIAMServiceAccountRole(spec v1alpha1.IAMServiceAccountRoleSpec) *v1alpha1.IAMServiceAccountRole {
+	return &v1alpha1.IAMServiceAccountRole{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:  "my-namespace",
+			Name:       "my-role",
+			Generation: 2,
+		},
+		Spec: spec,
+	}
+}
+
+func policyConfigMapRef(key string) *corev1.ConfigMapKeySelector {
+	return &corev1.ConfigMapKeySelector{
+		LocalObjectReference: corev1.LocalObjectReference{Name: "my-policy"},
+		Key:                  key,
+	}
+}
+
+func TestIAMServiceAccountRoleReconcilerReconcile(t *testing.T) {
+	testCases := []struct {
+		objects       []client.Object
+		err           bool
+		status        metav1.ConditionStatus
+		roles         map[string]string
+		policies      map[string]string
+		trustPolicies map[string]string
+	}{
+		// Inline policy document.
+		{
+			objects: []client.Object{
+				newIAMServiceAccountRole(v1alpha1.IAMServiceAccountRoleSpec{
+					PolicyDocument:  `{"Statement": []}`,
+					ServiceAccounts: []string{"my-app", "my-worker"},
+				}),
+			},
+			status:   metav1.ConditionTrue,
+			roles:    map[string]string{"eks-my-namespace_my-role": "eks-my-namespace_my-role"},
+			policies: map[string]string{"eks-my-namespace_my-role": `{"Statement": []}`},
+			trustPolicies: map[string]string{
+				"eks-my-namespace_my-role": `{"issuers": ["oidc.eks.us-east-1.amazonaws.com/id/EXAMPLE"], "serviceAccounts": [{"Namespace": "my-namespace", "Name": "my-app"}, {"Namespace": "my-namespace", "Name": "my-worker"}]}`,
+			},
+		},
+		// Policy document from a ConfigMap, role name and clusters set.
+		{
+			objects: []client.Object{
+				newIAMServiceAccountRole(v1alpha1.IAMServiceAccountRoleSpec{
+					RoleName:           "other-role",
+					PolicyConfigMapRef: policyConfigMapRef("policy.json"),
+					ServiceAccounts:    []string{"my-app"},
+					Clusters:           []string{"my-cluster"},
+				}),
+				newPolicyConfigMap(map[string]string{"policy.json": `{}`}),
+			},
+			status:   metav1.ConditionTrue,
+			roles:    map[string]string{"eks-my-namespace_other-role": "eks-my-namespace_other-role"},
+			policies: map[string]string{"eks-my-namespace_other-role": `{}`},
+			trustPolicies: map[string]string{
+				"eks-my-namespace_other-role": `{"issuers": ["oidc.eks.us-east-1.amazonaws.com/id/my-cluster"], "serviceAccounts": [{"Namespace": "my-namespace", "Name": "my-app"}]}`,
+			},
+		},
+		// ConfigMap key is missing.
+		{
+			objects: []client.Object{
+				newIAMServiceAccountRole(v1alpha1.IAMServiceAccountRoleSpec{
+					PolicyConfigMapRef: policyConfigMapRef("missing.json"),
+					ServiceAccounts:    []string{"my-app"},
+				}),
+				newPolicyConfigMap(map[string]string{"policy.json": `{}`}),
+			},
+			err:    true,
+			status: metav1.ConditionFalse,
+		},
+		// Both policy document and ConfigMap are set.
+		{
+			objects: []client.Object{
+				newIAMServiceAccountRole(v1alpha1.IAMServiceAccountRoleSpec{
+					PolicyDocument:     `{}`,
+					PolicyConfigMapRef: policyConfigMapRef("policy.json"),
+					ServiceAccounts:    []string{"my-app"},
+				}),
+			},
+			err:    true,
+			status: metav1.ConditionFalse,
+		},
+		// No service accounts.
+		{
+			objects: []client.Object{
+				newIAMServiceAccountRole(v1alpha1.IAMServiceAccountRoleSpec{
+					PolicyDocument: `{}`,
+				}),
+			},
+			err:    true,
+			status: metav1.ConditionFalse,
+		},
+	}
+	key := types.NamespacedName{Namespace: "my-namespace", Name: "my-role"}
+	for _, tc := range testCases {
+		aw := &mockedAWSWrapper{
+			policies:      make(map[string]string),
+			roles:         make(map[string]string),
+			trustPolicies: make(map[string]string),
+		}
+		r := &IAMServiceAccountRoleReconciler{
+			Client:       fake.NewClientBuilder().WithScheme(newScheme(t)).WithObjects(tc.objects...).Build(),
+			AWS:          aw,
+			Recorder:     record.NewFakeRecorder(10),
+			OIDCIssuers:  []string{"oidc.eks.us-east-1.amazonaws.com/id/EXAMPLE"},
+			RolePrefix:   "eks-",
+			ResyncPeriod: time.Hour,
+		}
+		result, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: key})
+		var role v1alpha1.IAMServiceAccountRole
+		assert.NoError(t, r.Get(context.Background(), key, &role))
+		assert.Equal(t, int64(2), role.Status.ObservedGeneration)
+		assert.Len(t, role.Status.Conditions, 1)
+		assert.Equal(t, v1alpha1.ConditionReady, role.Status.Conditions[0].Type)
+		assert.Equal(t, tc.status, role.Status.Conditions[0].Status)
+		if tc.err {
+			assert.Error(t, err)
+			assert.Empty(t, aw.roles)
+			assert.Empty(t, role.Status.RoleARN)
+			assert.Nil(t, role.Status.LastSyncTime)
+			continue
+		}
+		assert.NoError(t, err)
+		assert.Equal(t, ctrl.Result{RequeueAfter: time.Hour}, result)
+		assert.Equal(t, tc.roles, aw.roles)
+		assert.Equal(t, tc.policies, aw.policies)
+		for roleName, trustPolicy := range tc.trustPolicies {
+			assert.JSONEq(t, trustPolicy, aw.trustPolicies[roleName])
+			assert.Equal(t, "arn:aws:iam::123456789012:role/"+roleName, role.Status.RoleARN)
+			assert.Equal(t, "arn:aws:iam::123456789012:policy/"+roleName, role.Status.PolicyARN)
+		}
+		assert.Equal(t, "v1", role.Status.PolicyVersion)
+		assert.NotNil(t, role.Status.LastSyncTime)
+	}
+}
+
+func TestIAMServiceAccountRoleReconcilerNotFound(t *testing.T) {
+	r := &IAMServiceAccountRoleReconciler{
+		Client: fake.NewClientBuilder().WithScheme(newScheme(t)).Build(),
+	}
+	key := types.NamespacedName{Namespace: "my-namespace", Name: "my-role"}
+	result, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: key})
+	assert.NoError(t, err)
+	assert.Equal(t, ctrl.Result{}, result)
+}
+
+func TestRolesForConfigMap(t *testing.T) {
+	other := newIAMServiceAccountRole(v1alpha1.IAMServiceAccountRoleSpec{
+		PolicyDocument: `{}`,
+	})
+	other.Name = "other-role"
+	r := &IAMServiceAccountRoleReconciler{
+		Client: fake.NewClientBuilder().WithScheme(newScheme(t)).WithObjects(
+			newIAMServiceAccountRole(v1alpha1.IAMServiceAccountRoleSpec{
+				PolicyConfigMapRef: policyConfigMapRef("policy.json"),
+			}),
+			other,
+		).Build(),
+	}
+	requests := r.rolesForConfigMap(newPolicyConfigMap(nil))
+	assert.Len(t, requests, 1)
+	assert.Equal(t, "my-role", requests[0].Name)
+}
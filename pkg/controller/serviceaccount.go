@@ -0,0 +1,243 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"time"
+
+	"github.com/ldx/eks_iam_role/pkg/awswrapper"
+	"github.com/ldx/eks_iam_role/pkg/serviceaccount"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+const (
+	annotationPrefix = "eks-iam-role.ldx.github.com/"
+	// PolicyAnnotation on a service account is the name of the ConfigMap in
+	// its namespace with the policy document of its role in PolicyKey.
+	PolicyAnnotation = annotationPrefix + "policy"
+	// ConditionsAnnotation holds the conditions of a service account as a
+	// JSON list, since service accounts have no status.
+	ConditionsAnnotation = annotationPrefix + "conditions"
+	PolicyKey            = "policy.json"
+)
+
+const (
+	ConditionReady = "Ready"
+)
+
+// ServiceAccountReconciler ensures a role and policy for every service
+// account with a PolicyAnnotation, and annotates the service account with the
+// role ARN.
+type ServiceAccountReconciler struct {
+	client.Client
+	AWS      awswrapper.AWSWrapper
+	Recorder record.EventRecorder
+	// OIDCIssuers are trusted by the roles, they are usually the issuers of
+	// the clusters the controller watches.
+	OIDCIssuers []string
+	// Clusters are the names of the clusters the issuers belong to, they are
+	// only used for tagging roles and policies.
+	Clusters  []string
+	Audiences []string
+	// Tags are set on roles and policies besides the ownership tags.
+	Tags map[string]string
+	// PermissionsBoundary is the name or ARN of the permissions boundary of
+	// the roles. RemovePermissionsBoundary removes the boundary instead.
+	PermissionsBoundary       string
+	RemovePermissionsBoundary bool
+	// RolePath and PolicyPath are the IAM paths of roles and policies.
+	RolePath   string
+	PolicyPath string
+	// RoleDescription and MaxSessionDuration are set on the roles, see
+	// awswrapper.RoleOptions.
+	RoleDescription    string
+	MaxSessionDuration time.Duration
+	// RolePrefix is prepended to the names of roles and policies, so the
+	// controller can only manage roles starting with it.
+	RolePrefix string
+	// ResyncPeriod is how often up to date service accounts are reconciled
+	// again, to revert changes made to their roles outside of the controller.
+	ResyncPeriod time.Duration
+}
+
+// RoleName returns the name of the role and policy of a service account.
+func (r *ServiceAccountReconciler) RoleName(namespace, name string) (string, error) {
+	return roleName(r.RolePrefix, namespace, name)
+}
+
+func (r *ServiceAccountReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := ctrllog.FromContext(ctx)
+	var sa corev1.ServiceAccount
+	if err := r.Get(ctx, req.NamespacedName, &sa); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	configMapName, ok := sa.Annotations[PolicyAnnotation]
+	if !ok {
+		return ctrl.Result{}, nil
+	}
+	roleARN, changed, err := r.ensure(ctx, &sa, configMapName)
+	condition := metav1.Condition{
+		Type:    ConditionReady,
+		Status:  metav1.ConditionTrue,
+		Reason:  "Reconciled",
+		Message: "Role " + roleARN + " is up to date",
+	}
+	if err != nil {
+		log.Error(err, "Reconciling service account")
+		r.Recorder.Event(&sa, corev1.EventTypeWarning, "ReconcileFailed", err.Error())
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "ReconcileFailed"
+		condition.Message = err.Error()
+	} else if changed {
+		r.Recorder.Eventf(&sa, corev1.EventTypeNormal, "Reconciled", "Role %s updated", roleARN)
+	}
+	if statusErr := r.updateStatus(ctx, &sa, roleARN, condition); statusErr != nil {
+		return ctrl.Result{}, statusErr
+	}
+	if err != nil {
+		// Returning the error requeues the service account with backoff.
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{RequeueAfter: r.ResyncPeriod}, nil
+}
+
+// ensure ensures the policy and role of the service account, and returns the
+// role ARN and whether anything was changed.
+func (r *ServiceAccountReconciler) ensure(ctx context.Context, sa *corev1.ServiceAccount, configMapName string) (string, bool, error) {
+	roleName, err := r.RoleName(sa.Namespace, sa.Name)
+	if err != nil {
+		return "", false, err
+	}
+	var cm corev1.ConfigMap
+	key := types.NamespacedName{Namespace: sa.Namespace, Name: configMapName}
+	if err := r.Get(ctx, key, &cm); err != nil {
+		return "", false, errors.Wrapf(err, "getting policy config map %s", configMapName)
+	}
+	policyDocument, ok := cm.Data[PolicyKey]
+	if !ok {
+		return "", false, errors.Errorf("policy config map %s has no %s", configMapName, PolicyKey)
+	}
+	trustOpts := awswrapper.TrustOptions{
+		ServiceAccounts: []awswrapper.ServiceAccount{
+			{
+				Namespace: sa.Namespace,
+				Name:      sa.Name,
+			},
+		},
+		Audiences: r.Audiences,
+	}
+	policyResult, roleResult, err := ensureRole(r.AWS, roleName, []byte(policyDocument), r.OIDCIssuers, trustOpts, r.Clusters, awswrapper.RoleOptions{
+		Path:                      r.RolePath,
+		PolicyPath:                r.PolicyPath,
+		Tags:                      r.Tags,
+		PermissionsBoundary:       r.PermissionsBoundary,
+		RemovePermissionsBoundary: r.RemovePermissionsBoundary,
+		Description:               r.RoleDescription,
+		MaxSessionDuration:        r.MaxSessionDuration,
+	})
+	if err != nil {
+		return "", false, err
+	}
+	return roleResult.ARN, policyResult.Changed() || roleResult.Changed(), nil
+}
+
+// updateStatus sets the condition and, if it is known, the role ARN
+// annotation of the service account. The service account is only patched if
+// its annotations change.
+func (r *ServiceAccountReconciler) updateStatus(ctx context.Context, sa *corev1.ServiceAccount, roleARN string, condition metav1.Condition) error {
+	var conditions []metav1.Condition
+	if buf, ok := sa.Annotations[ConditionsAnnotation]; ok {
+		// Invalid conditions are overwritten.
+		_ = json.Unmarshal([]byte(buf), &conditions)
+	}
+	meta.SetStatusCondition(&conditions, condition)
+	buf, err := json.Marshal(conditions)
+	if err != nil {
+		return err
+	}
+	annotations := make(map[string]string, len(sa.Annotations)+2)
+	for key, value := range sa.Annotations {
+		annotations[key] = value
+	}
+	annotations[ConditionsAnnotation] = string(buf)
+	if roleARN != "" {
+		annotations[serviceaccount.RoleARNAnnotation] = roleARN
+	}
+	if reflect.DeepEqual(annotations, sa.Annotations) {
+		return nil
+	}
+	patch := client.MergeFrom(sa.DeepCopy())
+	sa.Annotations = annotations
+	if err := r.Patch(ctx, sa, patch); err != nil {
+		return errors.Wrapf(err, "updating service account %s/%s", sa.Namespace, sa.Name)
+	}
+	return nil
+}
+
+func hasPolicyAnnotation(obj client.Object) bool {
+	_, ok := obj.GetAnnotations()[PolicyAnnotation]
+	return ok
+}
+
+// ignoreOwnAnnotations drops updates of service accounts that only change the
+// annotations set by the controller. Otherwise patching the conditions after
+// a failure, e.g. with a new AWS request ID in the message, would requeue the
+// service account right away instead of with backoff.
+var ignoreOwnAnnotations = predicate.Funcs{
+	UpdateFunc: func(e event.UpdateEvent) bool {
+		return !reflect.DeepEqual(withoutOwnAnnotations(e.ObjectOld), withoutOwnAnnotations(e.ObjectNew))
+	},
+}
+
+func withoutOwnAnnotations(obj client.Object) map[string]string {
+	annotations := make(map[string]string)
+	for key, value := range obj.GetAnnotations() {
+		if key != ConditionsAnnotation && key != serviceaccount.RoleARNAnnotation {
+			annotations[key] = value
+		}
+	}
+	return annotations
+}
+
+// serviceAccountsForConfigMap returns the service accounts referencing the
+// ConfigMap, so they are reconciled when their policy document changes.
+func (r *ServiceAccountReconciler) serviceAccountsForConfigMap(obj client.Object) []reconcile.Request {
+	var serviceAccounts corev1.ServiceAccountList
+	if err := r.List(context.Background(), &serviceAccounts, client.InNamespace(obj.GetNamespace())); err != nil {
+		return nil
+	}
+	var requests []reconcile.Request
+	for _, sa := range serviceAccounts.Items {
+		if sa.Annotations[PolicyAnnotation] == obj.GetName() {
+			requests = append(requests, reconcile.Request{
+				NamespacedName: types.NamespacedName{
+					Namespace: sa.Namespace,
+					Name:      sa.Name,
+				},
+			})
+		}
+	}
+	return requests
+}
+
+func (r *ServiceAccountReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&corev1.ServiceAccount{}, builder.WithPredicates(predicate.NewPredicateFuncs(hasPolicyAnnotation), ignoreOwnAnnotations)).
+		Watches(&source.Kind{Type: &corev1.ConfigMap{}}, handler.EnqueueRequestsFromMapFunc(r.serviceAccountsForConfigMap)).
+		Complete(r)
+}
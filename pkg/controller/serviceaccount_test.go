@@ -0,0 +1,248 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ldx/eks_iam_role/pkg/awswrapper"
+	"github.com/ldx/eks_iam_role/pkg/serviceaccount"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+type mockedAWSWrapper struct {
+	awswrapper.AWSWrapper
+	ensurePolicyErr error
+	policies        map[string]string
+	roles           map[string]string
+	roleTags        map[string]map[string]string
+	trustPolicies   map[string]string
+}
+
+func (m *mockedAWSWrapper) EnsurePolicy(policyName string, policyDocument []byte, opts awswrapper.PolicyOptions) (*awswrapper.EnsureResult, error) {
+	if m.ensurePolicyErr != nil {
+		return nil, m.ensurePolicyErr
+	}
+	m.policies[policyName] = string(policyDocument)
+	return &awswrapper.EnsureResult{ARN: "arn:aws:iam::123456789012:policy/" + policyName, VersionID: "v1"}, nil
+}
+
+func (m *mockedAWSWrapper) EnsureRole(roleName string, policies []string, trustPolicy string, opts awswrapper.RoleOptions) (*awswrapper.EnsureResult, error) {
+	m.roles[roleName] = strings.Join(policies, ",")
+	if m.roleTags != nil {
+		m.roleTags[roleName] = opts.Tags
+	}
+	if m.trustPolicies != nil {
+		m.trustPolicies[roleName] = trustPolicy
+	}
+	return &awswrapper.EnsureResult{ARN: "arn:aws:iam::123456789012:role/" + roleName}, nil
+}
+
+func (m *mockedAWSWrapper) OIDCIssuersFromClusters(clusterNames []string) ([]string, error) {
+	var issuers []string
+	for _, cluster := range clusterNames {
+		issuers = append(issuers, "oidc.eks.us-east-1.amazonaws.com/id/"+cluster)
+	}
+	return issuers, nil
+}
+
+// TrustPolicyFromOIDCIssuers records the issuers and service accounts in the
+// returned trust policy.
+func (m *mockedAWSWrapper) TrustPolicyFromOIDCIssuers(issuers []string, opts awswrapper.TrustOptions) (string, error) {
+	buf, err := json.Marshal(map[string]interface{}{
+		"issuers":         issuers,
+		"serviceAccounts": opts.ServiceAccounts,
+	})
+	return string(buf), err
+}
+
+func newServiceAccount(annotations map[string]string) *corev1.ServiceAccount {
+	return &corev1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   "my-namespace",
+			Name:        "my-app",
+			Annotations: annotations,
+		},
+	}
+}
+
+func newPolicyConfigMap(data map[string]string) *corev1.ConfigMap {
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "my-namespace",
+			Name:      "my-policy",
+		},
+		Data: data,
+	}
+}
+
+func conditionsOf(t *testing.T, sa *corev1.ServiceAccount) []metav1.Condition {
+	var conditions []metav1.Condition
+	err := json.Unmarshal([]byte(sa.Annotations[ConditionsAnnotation]), &conditions)
+	assert.NoError(t, err)
+	return conditions
+}
+
+func TestServiceAccountReconcilerReconcile(t *testing.T) {
+	testCases := []struct {
+		objects         []client.Object
+		ensurePolicyErr error
+		err             bool
+		roleARN         string
+		status          metav1.ConditionStatus
+		roles           map[string]string
+	}{
+		// Service account with policy.
+		{
+			objects: []client.Object{
+				newServiceAccount(map[string]string{PolicyAnnotation: "my-policy"}),
+				newPolicyConfigMap(map[string]string{PolicyKey: `{"Statement": []}`}),
+			},
+			roleARN: "arn:aws:iam::123456789012:role/eks-my-namespace_my-app",
+			status:  metav1.ConditionTrue,
+			roles: map[string]string{
+				"eks-my-namespace_my-app": "eks-my-namespace_my-app",
+			},
+		},
+		// Service account without policy annotation is ignored.
+		{
+			objects: []client.Object{
+				newServiceAccount(nil),
+			},
+			roles: map[string]string{},
+		},
+		// Missing config map.
+		{
+			objects: []client.Object{
+				newServiceAccount(map[string]string{PolicyAnnotation: "my-policy"}),
+			},
+			err:    true,
+			status: metav1.ConditionFalse,
+			roles:  map[string]string{},
+		},
+		// Config map without policy document.
+		{
+			objects: []client.Object{
+				newServiceAccount(map[string]string{PolicyAnnotation: "my-policy"}),
+				newPolicyConfigMap(map[string]string{"other.json": `{}`}),
+			},
+			err:    true,
+			status: metav1.ConditionFalse,
+			roles:  map[string]string{},
+		},
+		// IAM error.
+		{
+			objects: []client.Object{
+				newServiceAccount(map[string]string{PolicyAnnotation: "my-policy"}),
+				newPolicyConfigMap(map[string]string{PolicyKey: `{"Statement": []}`}),
+			},
+			ensurePolicyErr: errors.New("access denied"),
+			err:             true,
+			status:          metav1.ConditionFalse,
+			roles:           map[string]string{},
+		},
+	}
+	key := types.NamespacedName{Namespace: "my-namespace", Name: "my-app"}
+	for _, tc := range testCases {
+		aw := &mockedAWSWrapper{
+			ensurePolicyErr: tc.ensurePolicyErr,
+			policies:        make(map[string]string),
+			roles:           make(map[string]string),
+		}
+		r := &ServiceAccountReconciler{
+			Client:       fake.NewClientBuilder().WithObjects(tc.objects...).Build(),
+			AWS:          aw,
+			Recorder:     record.NewFakeRecorder(10),
+			OIDCIssuers:  []string{"oidc.eks.us-east-1.amazonaws.com/id/EXAMPLE"},
+			RolePrefix:   "eks-",
+			ResyncPeriod: time.Hour,
+		}
+		result, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: key})
+		if tc.err {
+			assert.Error(t, err)
+		} else {
+			assert.NoError(t, err)
+		}
+		assert.Equal(t, tc.roles, aw.roles)
+		var sa corev1.ServiceAccount
+		err = r.Get(context.Background(), key, &sa)
+		assert.NoError(t, err)
+		if tc.status == "" {
+			assert.NotContains(t, sa.Annotations, ConditionsAnnotation)
+			continue
+		}
+		conditions := conditionsOf(t, &sa)
+		assert.Len(t, conditions, 1)
+		assert.Equal(t, ConditionReady, conditions[0].Type)
+		assert.Equal(t, tc.status, conditions[0].Status)
+		if tc.roleARN != "" {
+			assert.Equal(t, tc.roleARN, sa.Annotations[serviceaccount.RoleARNAnnotation])
+			assert.Equal(t, ctrl.Result{RequeueAfter: time.Hour}, result)
+		} else {
+			assert.NotContains(t, sa.Annotations, serviceaccount.RoleARNAnnotation)
+		}
+	}
+}
+
+// TestServiceAccountReconcilerFailureLoop checks that a failing reconcile
+// updating the conditions annotation does not trigger another one.
+func TestServiceAccountReconcilerFailureLoop(t *testing.T) {
+	aw := &mockedAWSWrapper{
+		ensurePolicyErr: errors.New("AccessDenied: status code: 403, request id: 1"),
+		policies:        make(map[string]string),
+		roles:           make(map[string]string),
+	}
+	r := &ServiceAccountReconciler{
+		Client: fake.NewClientBuilder().WithObjects(
+			newServiceAccount(map[string]string{PolicyAnnotation: "my-policy"}),
+			newPolicyConfigMap(map[string]string{PolicyKey: `{"Statement": []}`}),
+		).Build(),
+		AWS:         aw,
+		Recorder:    record.NewFakeRecorder(10),
+		OIDCIssuers: []string{"oidc.eks.us-east-1.amazonaws.com/id/EXAMPLE"},
+		RolePrefix:  "eks-",
+	}
+	key := types.NamespacedName{Namespace: "my-namespace", Name: "my-app"}
+	_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: key})
+	assert.Error(t, err)
+	var before corev1.ServiceAccount
+	assert.NoError(t, r.Get(context.Background(), key, &before))
+
+	aw.ensurePolicyErr = errors.New("AccessDenied: status code: 403, request id: 2")
+	_, err = r.Reconcile(context.Background(), ctrl.Request{NamespacedName: key})
+	assert.Error(t, err)
+	var after corev1.ServiceAccount
+	assert.NoError(t, r.Get(context.Background(), key, &after))
+	assert.NotEqual(t, before.Annotations[ConditionsAnnotation], after.Annotations[ConditionsAnnotation])
+	assert.False(t, ignoreOwnAnnotations.Update(event.UpdateEvent{ObjectOld: &before, ObjectNew: &after}))
+
+	// Changes by users are still reconciled.
+	changed := after.DeepCopy()
+	changed.Annotations[PolicyAnnotation] = "other-policy"
+	assert.True(t, ignoreOwnAnnotations.Update(event.UpdateEvent{ObjectOld: &after, ObjectNew: changed}))
+}
+
+func TestServiceAccountsForConfigMap(t *testing.T) {
+	other := newServiceAccount(map[string]string{PolicyAnnotation: "other-policy"})
+	other.Name = "other-app"
+	r := &ServiceAccountReconciler{
+		Client: fake.NewClientBuilder().WithObjects(
+			newServiceAccount(map[string]string{PolicyAnnotation: "my-policy"}),
+			other,
+		).Build(),
+	}
+	requests := r.serviceAccountsForConfigMap(newPolicyConfigMap(nil))
+	assert.Len(t, requests, 1)
+	assert.Equal(t, "my-app", requests[0].Name)
+}
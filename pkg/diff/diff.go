@@ -0,0 +1,172 @@
+package diff
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+type Op int
+
+const (
+	Equal Op = iota
+	Delete
+	Insert
+)
+
+// Line is a line of an edit script turning one text into another.
+type Line struct {
+	Op   Op
+	Text string
+}
+
+const (
+	contextLines = 3
+
+	colorReset = "\x1b[0m"
+	colorBold  = "\x1b[1m"
+	colorRed   = "\x1b[31m"
+	colorGreen = "\x1b[32m"
+	colorCyan  = "\x1b[36m"
+)
+
+// ColorEnabled reports whether output to f should be colorized: f has to be a
+// terminal, and the NO_COLOR environment variable must not be set.
+func ColorEnabled(f *os.File) bool {
+	if _, ok := os.LookupEnv("NO_COLOR"); ok {
+		return false
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeCharDevice != 0
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// Lines returns a minimal edit script turning a into b, based on their
+// longest common subsequence.
+func Lines(a, b []string) []Line {
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and
+	// b[j:].
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	var lines []Line
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			lines = append(lines, Line{Op: Equal, Text: a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			lines = append(lines, Line{Op: Delete, Text: a[i]})
+			i++
+		default:
+			lines = append(lines, Line{Op: Insert, Text: b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		lines = append(lines, Line{Op: Delete, Text: a[i]})
+	}
+	for ; j < len(b); j++ {
+		lines = append(lines, Line{Op: Insert, Text: b[j]})
+	}
+	return lines
+}
+
+// Unified returns the unified diff of two texts, or an empty string if they
+// are equal. fromName and toName label the texts in the header.
+func Unified(from, to, fromName, toName string, color bool) string {
+	lines := Lines(splitLines(from), splitLines(to))
+	var changes []int
+	for i, l := range lines {
+		if l.Op != Equal {
+			changes = append(changes, i)
+		}
+	}
+	if len(changes) == 0 {
+		return ""
+	}
+	paint := func(code, s string) string {
+		if !color {
+			return s
+		}
+		return code + s + colorReset
+	}
+	var sb strings.Builder
+	sb.WriteString(paint(colorBold, "--- "+fromName) + "\n")
+	sb.WriteString(paint(colorBold, "+++ "+toName) + "\n")
+	// Changes closer than twice the context are in the same hunk.
+	for k := 0; k < len(changes); {
+		first := changes[k]
+		last := first
+		for k++; k < len(changes) && changes[k]-last <= 2*contextLines; k++ {
+			last = changes[k]
+		}
+		start := first - contextLines
+		if start < 0 {
+			start = 0
+		}
+		end := last + contextLines + 1
+		if end > len(lines) {
+			end = len(lines)
+		}
+		fromStart, toStart := 1, 1
+		for _, l := range lines[:start] {
+			if l.Op != Insert {
+				fromStart++
+			}
+			if l.Op != Delete {
+				toStart++
+			}
+		}
+		fromCount, toCount := 0, 0
+		for _, l := range lines[start:end] {
+			if l.Op != Insert {
+				fromCount++
+			}
+			if l.Op != Delete {
+				toCount++
+			}
+		}
+		if fromCount == 0 {
+			fromStart--
+		}
+		if toCount == 0 {
+			toStart--
+		}
+		sb.WriteString(paint(colorCyan, fmt.Sprintf("@@ -%d,%d +%d,%d @@", fromStart, fromCount, toStart, toCount)) + "\n")
+		for _, l := range lines[start:end] {
+			switch l.Op {
+			case Equal:
+				sb.WriteString(" " + l.Text + "\n")
+			case Delete:
+				sb.WriteString(paint(colorRed, "-"+l.Text) + "\n")
+			case Insert:
+				sb.WriteString(paint(colorGreen, "+"+l.Text) + "\n")
+			}
+		}
+	}
+	return sb.String()
+}
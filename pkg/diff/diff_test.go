@@ -0,0 +1,91 @@
+package diff
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLines(t *testing.T) {
+	lines := Lines([]string{"a", "b", "c"}, []string{"a", "c", "d"})
+	assert.Equal(t, []Line{
+		{Op: Equal, Text: "a"},
+		{Op: Delete, Text: "b"},
+		{Op: Equal, Text: "c"},
+		{Op: Insert, Text: "d"},
+	}, lines)
+	assert.Empty(t, Lines(nil, nil))
+}
+
+func TestUnified(t *testing.T) {
+	testCases := []struct {
+		from     string
+		to       string
+		expected string
+	}{
+		{
+			from:     "a\nb\nc",
+			to:       "a\nb\nc",
+			expected: "",
+		},
+		{
+			from: "",
+			to:   "a\nb",
+			expected: `--- current
++++ desired
+@@ -0,0 +1,2 @@
++a
++b
+`,
+		},
+		{
+			from: "a\nb\nc",
+			to:   "a\nx\nc",
+			expected: `--- current
++++ desired
+@@ -1,3 +1,3 @@
+ a
+-b
++x
+ c
+`,
+		},
+		{
+			// Changes far apart are in separate hunks.
+			from: "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12",
+			to:   "0\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n13",
+			expected: `--- current
++++ desired
+@@ -1,4 +1,4 @@
+-1
++0
+ 2
+ 3
+ 4
+@@ -10,3 +10,4 @@
+ 10
+ 11
+ 12
++13
+`,
+		},
+	}
+	for _, tc := range testCases {
+		assert.Equal(t, tc.expected, Unified(tc.from, tc.to, "current", "desired", false))
+	}
+}
+
+func TestUnifiedColor(t *testing.T) {
+	diff := Unified("a", "b", "current", "desired", true)
+	assert.True(t, strings.Contains(diff, colorRed+"-a"+colorReset))
+	assert.True(t, strings.Contains(diff, colorGreen+"+b"+colorReset))
+}
+
+func TestColorEnabled(t *testing.T) {
+	f, err := ioutil.TempFile(t.TempDir(), "output")
+	assert.NoError(t, err)
+	defer f.Close()
+	assert.False(t, ColorEnabled(f))
+}
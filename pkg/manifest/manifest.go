@@ -0,0 +1,287 @@
+package manifest
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// Manifest declares roles and policies to be reconciled together. It can be
+// written in YAML or JSON:
+//
+//	clusters: [my-cluster]
+//	policies:
+//	  - name: s3-read
+//	    documentFile: policies/s3-read.json
+//	roles:
+//	  - name: my-app
+//	    policy: s3-read
+//	    serviceAccounts:
+//	      - namespace: my-namespace
+//	        name: my-app
+type Manifest struct {
+	// Clusters and OIDCIssuers are used for roles that don't set their own.
+	Clusters    []string `yaml:"clusters"`
+	OIDCIssuers []string `yaml:"oidcIssuers"`
+	Policies    []Policy `yaml:"policies"`
+	Roles       []Role   `yaml:"roles"`
+}
+
+// Policy is a customer managed policy. The document is either given inline,
+// or read from DocumentFile, relative to the manifest.
+type Policy struct {
+	Name         string   `yaml:"name"`
+	Document     Document `yaml:"document"`
+	DocumentFile string   `yaml:"documentFile"`
+}
+
+type Role struct {
+	Name string `yaml:"name"`
+	// Policy is the name of the policy attached to the role, by default it
+	// is the role name. If PolicyDocument or PolicyDocumentFile is set, the
+	// policy is ensured with that document, otherwise it has to be declared
+	// in the manifest or already exist.
+	Policy             string   `yaml:"policy"`
+	PolicyDocument     Document `yaml:"policyDocument"`
+	PolicyDocumentFile string   `yaml:"policyDocumentFile"`
+	// InlinePolicy puts the policy document into the role as an inline
+	// policy named PolicyName instead of a managed policy.
+	InlinePolicy bool `yaml:"inlinePolicy"`
+	// AttachPolicies are attached to the role besides Policy, given by name
+	// or ARN, e.g. arn:aws:iam::aws:policy/AmazonS3ReadOnlyAccess.
+	AttachPolicies []string `yaml:"attachPolicies"`
+	// Description and MaxSessionDuration, e.g. 4h, are set on the role. If
+	// they are empty, the ones given on the command line are used.
+	Description        string           `yaml:"description"`
+	MaxSessionDuration time.Duration    `yaml:"maxSessionDuration"`
+	Clusters           []string         `yaml:"clusters"`
+	OIDCIssuers        []string         `yaml:"oidcIssuers"`
+	ServiceAccounts    []ServiceAccount `yaml:"serviceAccounts"`
+	Audiences          []string         `yaml:"audiences"`
+	AllowBroadPatterns bool             `yaml:"allowBroadPatterns"`
+}
+
+type ServiceAccount struct {
+	Namespace string `yaml:"namespace"`
+	Name      string `yaml:"name"`
+}
+
+// Document is a JSON policy document. In the manifest it can be given as a
+// YAML mapping, or as a string containing JSON.
+type Document []byte
+
+func (d *Document) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode && node.Tag == "!!null" {
+		*d = nil
+		return nil
+	}
+	if node.Kind == yaml.ScalarNode && node.Tag == "!!str" {
+		if !json.Valid([]byte(node.Value)) {
+			return errors.Errorf("line %d: policy document is not valid JSON", node.Line)
+		}
+		*d = Document(node.Value)
+		return nil
+	}
+	doc, err := jsonValue(node)
+	if err != nil {
+		return err
+	}
+	buf, err := json.Marshal(doc)
+	if err != nil {
+		return errors.Wrapf(err, "line %d: converting policy document to JSON", node.Line)
+	}
+	*d = buf
+	return nil
+}
+
+// jsonValue converts the YAML node to a value that can be marshaled to JSON.
+// Scalars JSON has no type for, e.g. the timestamp an unquoted policy Version
+// like 2012-10-17 is resolved to, are kept as they were written.
+func jsonValue(node *yaml.Node) (interface{}, error) {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		return jsonValue(node.Content[0])
+	case yaml.AliasNode:
+		return jsonValue(node.Alias)
+	case yaml.MappingNode:
+		m := make(map[string]interface{}, len(node.Content)/2)
+		for i := 0; i < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			if key.Kind != yaml.ScalarNode || key.Tag == "!!merge" {
+				return nil, errors.Errorf("line %d: unsupported key in policy document", key.Line)
+			}
+			v, err := jsonValue(value)
+			if err != nil {
+				return nil, err
+			}
+			m[key.Value] = v
+		}
+		return m, nil
+	case yaml.SequenceNode:
+		s := make([]interface{}, 0, len(node.Content))
+		for _, item := range node.Content {
+			v, err := jsonValue(item)
+			if err != nil {
+				return nil, err
+			}
+			s = append(s, v)
+		}
+		return s, nil
+	}
+	switch node.Tag {
+	case "!!null", "!!bool", "!!int", "!!float":
+		var v interface{}
+		if err := node.Decode(&v); err != nil {
+			return nil, err
+		}
+		return v, nil
+	}
+	return node.Value, nil
+}
+
+// Load reads and validates a manifest, and reads all the policy document
+// files it refers to.
+func Load(path string) (*Manifest, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	m, err := Parse(buf)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing manifest %s", path)
+	}
+	if err := m.readDocumentFiles(filepath.Dir(path)); err != nil {
+		return nil, err
+	}
+	if err := m.Validate(); err != nil {
+		return nil, errors.Wrapf(err, "validating manifest %s", path)
+	}
+	return m, nil
+}
+
+// Parse parses a manifest without reading document files or validating it.
+func Parse(buf []byte) (*Manifest, error) {
+	var m Manifest
+	dec := yaml.NewDecoder(bytes.NewReader(buf))
+	dec.KnownFields(true)
+	if err := dec.Decode(&m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+func (m *Manifest) readDocumentFiles(dir string) error {
+	read := func(doc *Document, path string) error {
+		if path == "" {
+			return nil
+		}
+		if len(*doc) > 0 {
+			return errors.Errorf("both a document and document file %s are set", path)
+		}
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		buf, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		*doc = buf
+		return nil
+	}
+	for i := range m.Policies {
+		p := &m.Policies[i]
+		if err := read(&p.Document, p.DocumentFile); err != nil {
+			return errors.Wrapf(err, "policy %s", p.Name)
+		}
+	}
+	for i := range m.Roles {
+		r := &m.Roles[i]
+		if err := read(&r.PolicyDocument, r.PolicyDocumentFile); err != nil {
+			return errors.Wrapf(err, "role %s", r.Name)
+		}
+	}
+	return nil
+}
+
+// Validate checks that names are set and unique, that every policy has a
+// document, and that every role has service accounts and can be trusted by at
+// least one cluster or OIDC issuer.
+func (m *Manifest) Validate() error {
+	policies := make(map[string]bool)
+	for i, p := range m.Policies {
+		if p.Name == "" {
+			return errors.Errorf("policy %d has no name", i)
+		}
+		if policies[p.Name] {
+			return errors.Errorf("policy %s is declared more than once", p.Name)
+		}
+		policies[p.Name] = true
+		if len(p.Document) == 0 {
+			return errors.Errorf("policy %s has no document", p.Name)
+		}
+	}
+	roles := make(map[string]bool)
+	for i, r := range m.Roles {
+		if r.Name == "" {
+			return errors.Errorf("role %d has no name", i)
+		}
+		if roles[r.Name] {
+			return errors.Errorf("role %s is declared more than once", r.Name)
+		}
+		roles[r.Name] = true
+		if r.InlinePolicy && len(r.PolicyDocument) == 0 {
+			return errors.Errorf("role %s has an inline policy without document", r.Name)
+		}
+		if len(r.PolicyDocument) > 0 && !r.InlinePolicy {
+			if policies[r.PolicyName()] {
+				return errors.Errorf("role %s policy document conflicts with policy %s", r.Name, r.PolicyName())
+			}
+			policies[r.PolicyName()] = true
+		}
+		if len(r.ServiceAccounts) == 0 {
+			return errors.Errorf("role %s has no service accounts", r.Name)
+		}
+		for _, sa := range r.ServiceAccounts {
+			if sa.Namespace == "" || sa.Name == "" {
+				return errors.Errorf("role %s has a service account without namespace or name", r.Name)
+			}
+		}
+		clusters, issuers := m.Trusted(r)
+		if len(clusters) == 0 && len(issuers) == 0 {
+			return errors.Errorf("role %s has no clusters or OIDC issuers", r.Name)
+		}
+	}
+	return nil
+}
+
+// PolicyName returns the name of the policy attached to the role, or of its
+// inline policy.
+func (r Role) PolicyName() string {
+	if r.Policy != "" {
+		return r.Policy
+	}
+	return r.Name
+}
+
+// Policies returns the names or ARNs of all managed policies attached to the
+// role.
+func (r Role) Policies() []string {
+	if r.InlinePolicy {
+		return r.AttachPolicies
+	}
+	return append([]string{r.PolicyName()}, r.AttachPolicies...)
+}
+
+// Trusted returns the clusters and OIDC issuers the role trusts, falling back
+// to the manifest defaults if the role sets neither.
+func (m *Manifest) Trusted(r Role) ([]string, []string) {
+	if len(r.Clusters) == 0 && len(r.OIDCIssuers) == 0 {
+		return m.Clusters, m.OIDCIssuers
+	}
+	return r.Clusters, r.OIDCIssuers
+}
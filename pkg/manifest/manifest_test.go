@@ -0,0 +1,205 @@
+package manifest
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeFile(t *testing.T, dir, name, content string) string {
+	path := filepath.Join(dir, name)
+	err := ioutil.WriteFile(path, []byte(content), 0644)
+	assert.NoError(t, err)
+	return path
+}
+
+func TestLoadYAML(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "s3.json", `{"Version": "2012-10-17", "Statement": []}`)
+	path := writeFile(t, dir, "manifest.yaml", `
+clusters: [my-cluster]
+policies:
+  - name: s3-read
+    documentFile: s3.json
+  - name: sqs-read
+    document:
+      Version: "2012-10-17"
+      Statement:
+        - Effect: Allow
+          Action: ["sqs:ReceiveMessage"]
+          Resource: "*"
+roles:
+  - name: my-app
+    policy: s3-read
+    attachPolicies:
+      - sqs-read
+      - arn:aws:iam::aws:policy/AmazonS3ReadOnlyAccess
+    serviceAccounts:
+      - namespace: my-namespace
+        name: my-app
+  - name: my-other-app
+    policyDocument: '{"Version": "2012-10-17", "Statement": []}'
+    oidcIssuers: [https://my-issuer]
+    audiences: [my-audience]
+    serviceAccounts:
+      - namespace: blue
+        name: my-other-app
+      - namespace: green
+        name: my-other-app
+`)
+	m, err := Load(path)
+	assert.NoError(t, err)
+	assert.Len(t, m.Policies, 2)
+	assert.JSONEq(t, `{"Version": "2012-10-17", "Statement": []}`, string(m.Policies[0].Document))
+	assert.JSONEq(t, `{"Version": "2012-10-17", "Statement": [{"Effect": "Allow", "Action": ["sqs:ReceiveMessage"], "Resource": "*"}]}`, string(m.Policies[1].Document))
+	assert.Len(t, m.Roles, 2)
+	assert.Equal(t, "s3-read", m.Roles[0].PolicyName())
+	assert.Equal(t, []string{"s3-read", "sqs-read", "arn:aws:iam::aws:policy/AmazonS3ReadOnlyAccess"}, m.Roles[0].Policies())
+	clusters, issuers := m.Trusted(m.Roles[0])
+	assert.Equal(t, []string{"my-cluster"}, clusters)
+	assert.Empty(t, issuers)
+	assert.Equal(t, "my-other-app", m.Roles[1].PolicyName())
+	assert.Equal(t, []string{"my-other-app"}, m.Roles[1].Policies())
+	assert.JSONEq(t, `{"Version": "2012-10-17", "Statement": []}`, string(m.Roles[1].PolicyDocument))
+	clusters, issuers = m.Trusted(m.Roles[1])
+	assert.Empty(t, clusters)
+	assert.Equal(t, []string{"https://my-issuer"}, issuers)
+	assert.Equal(t, []ServiceAccount{
+		{Namespace: "blue", Name: "my-other-app"},
+		{Namespace: "green", Name: "my-other-app"},
+	}, m.Roles[1].ServiceAccounts)
+}
+
+func TestDocumentScalars(t *testing.T) {
+	m, err := Parse([]byte(`
+policies:
+  - name: s3-read
+    document:
+      Version: 2012-10-17
+      Statement:
+        - Effect: Allow
+          Action: &actions [s3:GetObject]
+          Resource: "*"
+          Condition:
+            Bool: {aws:SecureTransport: true}
+            NumericLessThanEquals: {s3:max-keys: 10}
+            DateGreaterThan: {aws:CurrentTime: 2024-01-01T00:00:00Z}
+        - Effect: Deny
+          Action: *actions
+          Resource: "*"
+`))
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{
+		"Version": "2012-10-17",
+		"Statement": [
+			{
+				"Effect": "Allow",
+				"Action": ["s3:GetObject"],
+				"Resource": "*",
+				"Condition": {
+					"Bool": {"aws:SecureTransport": true},
+					"NumericLessThanEquals": {"s3:max-keys": 10},
+					"DateGreaterThan": {"aws:CurrentTime": "2024-01-01T00:00:00Z"}
+				}
+			},
+			{"Effect": "Deny", "Action": ["s3:GetObject"], "Resource": "*"}
+		]
+	}`, string(m.Policies[0].Document))
+}
+
+func TestRoleSettings(t *testing.T) {
+	m, err := Parse([]byte(`
+roles:
+  - name: my-app
+  - name: my-batch-job
+    description: Nightly exports
+    maxSessionDuration: 6h
+`))
+	assert.NoError(t, err)
+	assert.Empty(t, m.Roles[0].Description)
+	assert.Zero(t, m.Roles[0].MaxSessionDuration)
+	assert.Equal(t, "Nightly exports", m.Roles[1].Description)
+	assert.Equal(t, 6*time.Hour, m.Roles[1].MaxSessionDuration)
+	_, err = Parse([]byte(`
+roles:
+  - name: my-app
+    maxSessionDuration: forever
+`))
+	assert.Error(t, err)
+}
+
+func TestLoadJSON(t *testing.T) {
+	dir := t.TempDir()
+	path := writeFile(t, dir, "manifest.json", `{
+  "oidcIssuers": ["my-issuer"],
+  "roles": [
+    {
+      "name": "my-app",
+      "policyDocument": {"Version": "2012-10-17", "Statement": []},
+      "serviceAccounts": [{"namespace": "my-namespace", "name": "my-app"}]
+    }
+  ]
+}`)
+	m, err := Load(path)
+	assert.NoError(t, err)
+	assert.Len(t, m.Roles, 1)
+	assert.JSONEq(t, `{"Version": "2012-10-17", "Statement": []}`, string(m.Roles[0].PolicyDocument))
+}
+
+func TestInlinePolicy(t *testing.T) {
+	m, err := Parse([]byte(`
+oidcIssuers: [my-issuer]
+policies:
+  - name: my-app
+    document: {}
+roles:
+  - name: my-app
+    policyDocument: {}
+    inlinePolicy: true
+    attachPolicies: [my-app]
+    serviceAccounts:
+      - namespace: my-namespace
+        name: my-app
+`))
+	assert.NoError(t, err)
+	// The inline policy does not conflict with the managed policy.
+	assert.NoError(t, m.Validate())
+	assert.Equal(t, "my-app", m.Roles[0].PolicyName())
+	assert.Equal(t, []string{"my-app"}, m.Roles[0].Policies())
+}
+
+func TestLoadInvalid(t *testing.T) {
+	testCases := []string{
+		// Unknown field.
+		`roles: [{name: my-app, serviceAccount: my-app}]`,
+		// Invalid JSON document string.
+		`policies: [{name: my-policy, document: "not json"}]`,
+		// Missing names.
+		`policies: [{document: {}}]`,
+		`roles: [{oidcIssuers: [my-issuer], serviceAccounts: [{namespace: ns, name: sa}]}]`,
+		// Duplicates.
+		`policies: [{name: my-policy, document: {}}, {name: my-policy, document: {}}]`,
+		`{oidcIssuers: [my-issuer], roles: [{name: r, serviceAccounts: [{namespace: ns, name: sa}]}, {name: r, serviceAccounts: [{namespace: ns, name: sa}]}]}`,
+		`{oidcIssuers: [my-issuer], policies: [{name: r, document: {}}], roles: [{name: r, policyDocument: {}, serviceAccounts: [{namespace: ns, name: sa}]}]}`,
+		// Policy without document.
+		`policies: [{name: my-policy}]`,
+		`{oidcIssuers: [my-issuer], roles: [{name: r, inlinePolicy: true, serviceAccounts: [{namespace: ns, name: sa}]}]}`,
+		// Role without service accounts or trusted clusters.
+		`{oidcIssuers: [my-issuer], roles: [{name: r}]}`,
+		`{oidcIssuers: [my-issuer], roles: [{name: r, serviceAccounts: [{name: sa}]}]}`,
+		`roles: [{name: r, serviceAccounts: [{namespace: ns, name: sa}]}]`,
+		// Missing document file.
+		`policies: [{name: my-policy, documentFile: missing.json}]`,
+		// Both document and document file.
+		`policies: [{name: my-policy, document: {}, documentFile: manifest.yaml}]`,
+	}
+	for _, tc := range testCases {
+		dir := t.TempDir()
+		path := writeFile(t, dir, "manifest.yaml", tc)
+		_, err := Load(path)
+		assert.Error(t, err, tc)
+	}
+}
@@ -0,0 +1,196 @@
+package serviceaccount
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ldx/eks_iam_role/pkg/awswrapper"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const (
+	STSRegionalEndpointsAnnotation = "eks.amazonaws.com/sts-regional-endpoints"
+	TokenExpirationAnnotation      = "eks.amazonaws.com/token-expiration"
+	// minTokenExpiration is the shortest token expiration in seconds the
+	// pod identity webhook accepts.
+	minTokenExpiration = 600
+)
+
+const (
+	ResourceServiceAccount = "service-account"
+)
+
+// Options are the annotations of a service account used by the EKS pod
+// identity webhook.
+type Options struct {
+	RoleARN string
+	// STSRegionalEndpoints makes pods use the regional STS endpoint instead
+	// of the global one.
+	STSRegionalEndpoints bool
+	// TokenExpiration is the expiration of projected tokens in seconds. If
+	// it is 0, the webhook default is used.
+	TokenExpiration int64
+}
+
+func (o Options) annotations() (map[string]string, error) {
+	if o.RoleARN == "" {
+		return nil, errors.New("empty role ARN")
+	}
+	annotations := map[string]string{
+		RoleARNAnnotation: o.RoleARN,
+	}
+	if o.STSRegionalEndpoints {
+		annotations[STSRegionalEndpointsAnnotation] = "true"
+	}
+	if o.TokenExpiration != 0 {
+		if err := ValidateTokenExpiration(o.TokenExpiration); err != nil {
+			return nil, err
+		}
+		annotations[TokenExpirationAnnotation] = strconv.FormatInt(o.TokenExpiration, 10)
+	}
+	return annotations, nil
+}
+
+// ValidateTokenExpiration checks the token expiration in seconds. 0 is valid,
+// it leaves the webhook default.
+func ValidateTokenExpiration(seconds int64) error {
+	if seconds != 0 && seconds < minTokenExpiration {
+		return errors.Errorf("token expiration %d is shorter than %d seconds", seconds, minTokenExpiration)
+	}
+	return nil
+}
+
+// NewRESTConfig loads the Kubernetes client config from the kubeconfig file,
+// or if it is empty, from $KUBECONFIG, ~/.kube/config or the in-cluster
+// config, in this order. An empty kubeContext selects the current context.
+func NewRESTConfig(kubeconfig, kubeContext string) (*rest.Config, error) {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = kubeconfig
+	overrides := &clientcmd.ConfigOverrides{
+		CurrentContext: kubeContext,
+	}
+	config, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides).ClientConfig()
+	if err != nil {
+		return nil, errors.Wrapf(err, "loading Kubernetes client config")
+	}
+	return config, nil
+}
+
+// NewClient creates a Kubernetes client, see NewRESTConfig.
+func NewClient(kubeconfig, kubeContext string) (kubernetes.Interface, error) {
+	config, err := NewRESTConfig(kubeconfig, kubeContext)
+	if err != nil {
+		return nil, err
+	}
+	return kubernetes.NewForConfig(config)
+}
+
+// Annotator creates and annotates service accounts via the Kubernetes API.
+type Annotator struct {
+	client kubernetes.Interface
+	dryRun bool
+}
+
+func NewAnnotator(client kubernetes.Interface, dryRun bool) *Annotator {
+	return &Annotator{
+		client: client,
+		dryRun: dryRun,
+	}
+}
+
+// Ensure creates the service account with the annotations of opts, or adds
+// them to the existing service account. Other annotations of the service
+// account are kept, and annotations are never removed.
+func (a *Annotator) Ensure(ctx context.Context, sa awswrapper.ServiceAccount, opts Options) ([]awswrapper.Change, error) {
+	if sa.IsPattern() {
+		return nil, errors.Errorf("service account %s is a pattern", sa)
+	}
+	desired, err := opts.annotations()
+	if err != nil {
+		return nil, err
+	}
+	serviceAccounts := a.client.CoreV1().ServiceAccounts(sa.Namespace)
+	current, err := serviceAccounts.Get(ctx, sa.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		if !a.dryRun {
+			_, err = serviceAccounts.Create(ctx, &corev1.ServiceAccount{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        sa.Name,
+					Namespace:   sa.Namespace,
+					Annotations: desired,
+				},
+			}, metav1.CreateOptions{})
+			if err != nil {
+				return nil, errors.Wrapf(err, "creating service account %s", sa)
+			}
+		}
+		return []awswrapper.Change{
+			{
+				Action:   awswrapper.ChangeCreate,
+				Resource: ResourceServiceAccount,
+				Name:     sa.String(),
+				After:    formatAnnotations(desired),
+			},
+		}, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "getting service account %s", sa)
+	}
+	before := make(map[string]string)
+	after := make(map[string]string)
+	for key, value := range desired {
+		if currentValue, ok := current.Annotations[key]; !ok || currentValue != value {
+			if ok {
+				before[key] = currentValue
+			}
+			after[key] = value
+		}
+	}
+	if len(after) == 0 {
+		return nil, nil
+	}
+	if !a.dryRun {
+		patch, err := json.Marshal(map[string]interface{}{
+			"metadata": map[string]interface{}{
+				"annotations": after,
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		_, err = serviceAccounts.Patch(ctx, sa.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+		if err != nil {
+			return nil, errors.Wrapf(err, "annotating service account %s", sa)
+		}
+	}
+	return []awswrapper.Change{
+		{
+			Action:   awswrapper.ChangeUpdate,
+			Resource: ResourceServiceAccount,
+			Name:     sa.String(),
+			Before:   formatAnnotations(before),
+			After:    formatAnnotations(after),
+		},
+	}, nil
+}
+
+// formatAnnotations returns the annotations as a sorted, comma separated list
+// of key=value pairs.
+func formatAnnotations(annotations map[string]string) string {
+	pairs := make([]string, 0, len(annotations))
+	for key, value := range annotations {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ", ")
+}
@@ -0,0 +1,179 @@
+package serviceaccount
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ldx/eks_iam_role/pkg/awswrapper"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const (
+	testRoleARN = "arn:aws:iam::123456789012:role/my-role"
+)
+
+func testServiceAccount(annotations map[string]string) *corev1.ServiceAccount {
+	return &corev1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "my-app",
+			Namespace:   "my-namespace",
+			Annotations: annotations,
+		},
+	}
+}
+
+func TestAnnotatorEnsure(t *testing.T) {
+	testCases := []struct {
+		existing    *corev1.ServiceAccount
+		opts        Options
+		dryRun      bool
+		changes     []awswrapper.Change
+		verbs       []string
+		annotations map[string]string
+	}{
+		// Service account does not exist.
+		{
+			opts: Options{RoleARN: testRoleARN},
+			changes: []awswrapper.Change{
+				{
+					Action:   awswrapper.ChangeCreate,
+					Resource: ResourceServiceAccount,
+					Name:     "my-namespace:my-app",
+					After:    RoleARNAnnotation + "=" + testRoleARN,
+				},
+			},
+			verbs: []string{"get", "create"},
+			annotations: map[string]string{
+				RoleARNAnnotation: testRoleARN,
+			},
+		},
+		// Service account is missing annotations, others are kept.
+		{
+			existing: testServiceAccount(map[string]string{
+				"example.com/owner":            "team-a",
+				TokenExpirationAnnotation:      "3600",
+				STSRegionalEndpointsAnnotation: "true",
+			}),
+			opts: Options{
+				RoleARN:              testRoleARN,
+				STSRegionalEndpoints: true,
+				TokenExpiration:      86400,
+			},
+			changes: []awswrapper.Change{
+				{
+					Action:   awswrapper.ChangeUpdate,
+					Resource: ResourceServiceAccount,
+					Name:     "my-namespace:my-app",
+					Before:   TokenExpirationAnnotation + "=3600",
+					After:    RoleARNAnnotation + "=" + testRoleARN + ", " + TokenExpirationAnnotation + "=86400",
+				},
+			},
+			verbs: []string{"get", "patch"},
+			annotations: map[string]string{
+				"example.com/owner":            "team-a",
+				RoleARNAnnotation:              testRoleARN,
+				STSRegionalEndpointsAnnotation: "true",
+				TokenExpirationAnnotation:      "86400",
+			},
+		},
+		// Service account is up to date.
+		{
+			existing: testServiceAccount(map[string]string{
+				RoleARNAnnotation: testRoleARN,
+			}),
+			opts:  Options{RoleARN: testRoleARN},
+			verbs: []string{"get"},
+			annotations: map[string]string{
+				RoleARNAnnotation: testRoleARN,
+			},
+		},
+		// Dry run does not create the service account.
+		{
+			opts:   Options{RoleARN: testRoleARN},
+			dryRun: true,
+			changes: []awswrapper.Change{
+				{
+					Action:   awswrapper.ChangeCreate,
+					Resource: ResourceServiceAccount,
+					Name:     "my-namespace:my-app",
+					After:    RoleARNAnnotation + "=" + testRoleARN,
+				},
+			},
+			verbs: []string{"get"},
+		},
+		// Dry run does not annotate the service account.
+		{
+			existing: testServiceAccount(nil),
+			opts:     Options{RoleARN: testRoleARN},
+			dryRun:   true,
+			changes: []awswrapper.Change{
+				{
+					Action:   awswrapper.ChangeUpdate,
+					Resource: ResourceServiceAccount,
+					Name:     "my-namespace:my-app",
+					After:    RoleARNAnnotation + "=" + testRoleARN,
+				},
+			},
+			verbs: []string{"get"},
+		},
+	}
+	sa := awswrapper.ServiceAccount{Namespace: "my-namespace", Name: "my-app"}
+	for _, tc := range testCases {
+		client := fake.NewSimpleClientset()
+		if tc.existing != nil {
+			client = fake.NewSimpleClientset(tc.existing)
+		}
+		a := NewAnnotator(client, tc.dryRun)
+		changes, err := a.Ensure(context.Background(), sa, tc.opts)
+		assert.NoError(t, err)
+		assert.Equal(t, tc.changes, changes)
+		var verbs []string
+		for _, action := range client.Actions() {
+			verbs = append(verbs, action.GetVerb())
+		}
+		assert.Equal(t, tc.verbs, verbs)
+		if tc.annotations != nil {
+			result, err := client.CoreV1().ServiceAccounts(sa.Namespace).Get(context.Background(), sa.Name, metav1.GetOptions{})
+			assert.NoError(t, err)
+			assert.Equal(t, tc.annotations, result.Annotations)
+		}
+	}
+}
+
+func TestAnnotatorEnsureInvalid(t *testing.T) {
+	testCases := []struct {
+		sa   awswrapper.ServiceAccount
+		opts Options
+	}{
+		{
+			sa:   awswrapper.ServiceAccount{Namespace: "preview-*", Name: "my-app"},
+			opts: Options{RoleARN: testRoleARN},
+		},
+		{
+			sa:   awswrapper.ServiceAccount{Namespace: "my-namespace", Name: "my-app"},
+			opts: Options{},
+		},
+		{
+			sa:   awswrapper.ServiceAccount{Namespace: "my-namespace", Name: "my-app"},
+			opts: Options{RoleARN: testRoleARN, TokenExpiration: 60},
+		},
+	}
+	for _, tc := range testCases {
+		client := fake.NewSimpleClientset()
+		a := NewAnnotator(client, false)
+		_, err := a.Ensure(context.Background(), tc.sa, tc.opts)
+		assert.Error(t, err)
+		assert.Empty(t, client.Actions())
+	}
+}
+
+func TestValidateTokenExpiration(t *testing.T) {
+	assert.NoError(t, ValidateTokenExpiration(0))
+	assert.NoError(t, ValidateTokenExpiration(600))
+	assert.NoError(t, ValidateTokenExpiration(86400))
+	assert.Error(t, ValidateTokenExpiration(100))
+	assert.Error(t, ValidateTokenExpiration(-1))
+}
@@ -0,0 +1,61 @@
+package serviceaccount
+
+import (
+	"bytes"
+
+	"github.com/ldx/eks_iam_role/pkg/awswrapper"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// RoleARNAnnotation is the annotation the EKS pod identity webhook uses
+	// to inject the role into pods using the service account.
+	RoleARNAnnotation = "eks.amazonaws.com/role-arn"
+)
+
+type serviceAccount struct {
+	APIVersion string   `yaml:"apiVersion"`
+	Kind       string   `yaml:"kind"`
+	Metadata   metadata `yaml:"metadata"`
+}
+
+type metadata struct {
+	Name        string            `yaml:"name"`
+	Namespace   string            `yaml:"namespace"`
+	Annotations map[string]string `yaml:"annotations"`
+}
+
+// Render returns a YAML manifest with a ServiceAccount with the annotations
+// of opts for each of the service accounts, ready for kubectl apply. Service
+// accounts with glob patterns can't be rendered.
+func Render(opts Options, serviceAccounts []awswrapper.ServiceAccount) ([]byte, error) {
+	annotations, err := opts.annotations()
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	for _, sa := range serviceAccounts {
+		if sa.IsPattern() {
+			return nil, errors.Errorf("service account %s is a pattern", sa)
+		}
+		err := enc.Encode(serviceAccount{
+			APIVersion: "v1",
+			Kind:       "ServiceAccount",
+			Metadata: metadata{
+				Name:        sa.Name,
+				Namespace:   sa.Namespace,
+				Annotations: annotations,
+			},
+		})
+		if err != nil {
+			return nil, errors.Wrapf(err, "encoding service account %s", sa)
+		}
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
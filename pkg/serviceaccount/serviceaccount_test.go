@@ -0,0 +1,105 @@
+package serviceaccount
+
+import (
+	"testing"
+
+	"github.com/ldx/eks_iam_role/pkg/awswrapper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRender(t *testing.T) {
+	testCases := []struct {
+		opts            Options
+		serviceAccounts []awswrapper.ServiceAccount
+		expected        string
+		err             bool
+	}{
+		{
+			opts: Options{RoleARN: "arn:aws:iam::123456789012:role/my-role"},
+			serviceAccounts: []awswrapper.ServiceAccount{
+				{Namespace: "my-namespace", Name: "my-app"},
+			},
+			expected: `apiVersion: v1
+kind: ServiceAccount
+metadata:
+  name: my-app
+  namespace: my-namespace
+  annotations:
+    eks.amazonaws.com/role-arn: arn:aws:iam::123456789012:role/my-role
+`,
+		},
+		{
+			opts: Options{RoleARN: "arn:aws:iam::123456789012:role/my-role"},
+			serviceAccounts: []awswrapper.ServiceAccount{
+				{Namespace: "my-namespace", Name: "my-app"},
+				{Namespace: "other-namespace", Name: "my-worker"},
+			},
+			expected: `apiVersion: v1
+kind: ServiceAccount
+metadata:
+  name: my-app
+  namespace: my-namespace
+  annotations:
+    eks.amazonaws.com/role-arn: arn:aws:iam::123456789012:role/my-role
+---
+apiVersion: v1
+kind: ServiceAccount
+metadata:
+  name: my-worker
+  namespace: other-namespace
+  annotations:
+    eks.amazonaws.com/role-arn: arn:aws:iam::123456789012:role/my-role
+`,
+		},
+		{
+			opts: Options{
+				RoleARN:              "arn:aws:iam::123456789012:role/my-role",
+				STSRegionalEndpoints: true,
+				TokenExpiration:      3600,
+			},
+			serviceAccounts: []awswrapper.ServiceAccount{
+				{Namespace: "my-namespace", Name: "my-app"},
+			},
+			expected: `apiVersion: v1
+kind: ServiceAccount
+metadata:
+  name: my-app
+  namespace: my-namespace
+  annotations:
+    eks.amazonaws.com/role-arn: arn:aws:iam::123456789012:role/my-role
+    eks.amazonaws.com/sts-regional-endpoints: "true"
+    eks.amazonaws.com/token-expiration: "3600"
+`,
+		},
+		{
+			opts: Options{RoleARN: "arn:aws:iam::123456789012:role/my-role", TokenExpiration: 60},
+			serviceAccounts: []awswrapper.ServiceAccount{
+				{Namespace: "my-namespace", Name: "my-app"},
+			},
+			err: true,
+		},
+		{
+			opts: Options{RoleARN: "arn:aws:iam::123456789012:role/my-role"},
+			serviceAccounts: []awswrapper.ServiceAccount{
+				{Namespace: "preview-*", Name: "my-app"},
+			},
+			err: true,
+		},
+		{
+			opts: Options{},
+			serviceAccounts: []awswrapper.ServiceAccount{
+				{Namespace: "my-namespace", Name: "my-app"},
+			},
+			err: true,
+		},
+	}
+	for _, tc := range testCases {
+		buf, err := Render(tc.opts, tc.serviceAccounts)
+		if tc.err {
+			assert.Error(t, err)
+			continue
+		}
+		assert.NoError(t, err)
+		assert.Equal(t, tc.expected, string(buf))
+	}
+}